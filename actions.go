@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// Table actions decide what happens to a table as a whole.
+const (
+	// actionTruncate drops the table's data while keeping its schema, so the
+	// table is created empty.
+	actionTruncate = "truncate"
+	// actionSkip removes the table from the dump altogether.
+	actionSkip = "skip"
+)
+
+func validateAction(action string) error {
+	switch action {
+	case "", actionTruncate, actionSkip:
+		return nil
+	}
+	return fmt.Errorf("unknown table action %q", action)
+}
+
+// tableActions returns the action configured for each table that has one.
+func (c Config) tableActions() map[string]string {
+	actions := make(map[string]string)
+	for _, pattern := range c.Patterns {
+		if pattern.Action != "" {
+			actions[pattern.TableName] = pattern.Action
+		}
+	}
+	return actions
+}
+
+// tableNamePattern matches a table name, quoted or not, capturing the name
+// without the database it may be qualified with, as in `db`.`foo`.
+const tableNamePattern = "(?:(?:`[^`]+`|[^`\\s(.;,]+)\\.)?`?([^`\\s(.;,]+)`?"
+
+var (
+	// tableStatementPattern matches the first line of the statements mysqldump
+	// writes about a table besides its INSERTs, including those wrapped in
+	// version comments such as /*!40000 ALTER TABLE `foo` DISABLE KEYS */
+	tableStatementPattern = regexp.MustCompile("(?i)^(?:/\\*!\\d*\\s*)?(?:DROP\\s+TABLE(?:\\s+IF\\s+EXISTS)?|CREATE\\s+TABLE(?:\\s+IF\\s+NOT\\s+EXISTS)?|LOCK\\s+TABLES|ALTER\\s+TABLE)\\s+" + tableNamePattern)
+	// tableCommentPattern matches comments such as
+	// -- Table structure for table `foo`
+	tableCommentPattern = regexp.MustCompile("(?i)^--.*\\btable\\s+`([^`]+)`")
+	lockTablesPattern   = regexp.MustCompile(`(?i)^LOCK\s+TABLES`)
+	unlockTablesPattern = regexp.MustCompile(`(?i)^UNLOCK\s+TABLES`)
+)
+
+// tableStatementName returns the table named by a line that starts one of the
+// statements or comments mysqldump writes about a table, other than INSERTs.
+func tableStatementName(line string) string {
+	if match := tableStatementPattern.FindStringSubmatch(line); match != nil {
+		return match[1]
+	}
+	if match := tableCommentPattern.FindStringSubmatch(line); match != nil {
+		return match[1]
+	}
+	return ""
+}
+
+// framingPattern matches what mysqldump surrounds a table's statements with:
+// blank lines, the bare -- lines framing comments, and the statements saving
+// and restoring the client character set around CREATE TABLE.
+var framingPattern = regexp.MustCompile(`^(?:|--|/\*!\d+ SET (?:@saved_cs_client|character_set_client)\s*=.*)$`)
+
+// isFramingLine reports whether a trimmed chunk might belong to the statement
+// after it, so it has to be held back until we know whether that statement is
+// skipped.
+func isFramingLine(line string) bool {
+	return framingPattern.MatchString(line)
+}
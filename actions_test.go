@@ -0,0 +1,84 @@
+package main
+
+import (
+	"testing"
+)
+
+// dumpTable returns a table the way mysqldump writes it.
+func dumpTable(table string, insert string) string {
+	return "--\n" +
+		"-- Table structure for table `" + table + "`\n" +
+		"--\n" +
+		"\n" +
+		"DROP TABLE IF EXISTS `" + table + "`;\n" +
+		"/*!40101 SET @saved_cs_client     = @@character_set_client */;\n" +
+		"CREATE TABLE `" + table + "` (\n" +
+		"`id` bigint(20) unsigned NOT NULL AUTO_INCREMENT,\n" +
+		"`value` longtext NOT NULL,\n" +
+		"PRIMARY KEY (`id`)\n" +
+		");\n" +
+		"/*!40101 SET character_set_client = @saved_cs_client */;\n" +
+		"\n" +
+		"--\n" +
+		"-- Dumping data for table `" + table + "`\n" +
+		"--\n" +
+		"\n" +
+		"LOCK TABLES `" + table + "` WRITE;\n" +
+		"/*!40000 ALTER TABLE `" + table + "` DISABLE KEYS */;\n" +
+		insert +
+		"/*!40000 ALTER TABLE `" + table + "` ENABLE KEYS */;\n" +
+		"UNLOCK TABLES;\n" +
+		"\n"
+}
+
+func TestTableActions(t *testing.T) {
+
+	var dump string
+	for _, table := range []string{"wp_actionscheduler_logs", "wp_options", "wp_woocommerce_sessions"} {
+		dump += dumpTable(table, "INSERT INTO `"+table+"` VALUES (1,'foo'),\n(2,'bar');\n")
+	}
+
+	config := Config{
+		Patterns: []ConfigPattern{
+			{TableName: "wp_actionscheduler_logs", Action: "skip"},
+			{TableName: "wp_woocommerce_sessions", Action: "truncate"},
+		},
+	}
+
+	// The skipped table's last blank line is the only trace of it left
+	wants := "\n" +
+		dumpTable("wp_options", "insert into wp_options values (1, 'foo'), (2, 'bar');\n") +
+		dumpTable("wp_woocommerce_sessions", "")
+
+	result := processString(t, config, dump)
+
+	if result != wants {
+		t.Error("\nExpected:\n", wants, "\nActual:\n", result)
+	}
+}
+
+func TestTableNames(t *testing.T) {
+	var tests = []struct {
+		statement string
+		wants     string
+	}{
+		{statement: "DROP TABLE IF EXISTS `wp_options`;", wants: "wp_options"},
+		{statement: "DROP TABLE IF EXISTS `wordpress`.`wp_options`;", wants: "wp_options"},
+		{statement: "LOCK TABLES wordpress.wp_options WRITE;", wants: "wp_options"},
+		{statement: "/*!40000 ALTER TABLE `wordpress`.wp_options DISABLE KEYS */;", wants: "wp_options"},
+		{statement: "CREATE TABLE wp_options(`id` bigint(20));", wants: "wp_options"},
+	}
+
+	for _, test := range tests {
+		if table := tableStatementName(test.statement); table != test.wants {
+			t.Errorf("%q: expected %q, got %q", test.statement, test.wants, table)
+		}
+	}
+
+	if table := insertTableName("INSERT INTO `wordpress`.`wp_options` VALUES (1);"); table != "wp_options" {
+		t.Errorf("Expected the INSERT statement's table to be wp_options, got %q", table)
+	}
+	if table := updateTableName("UPDATE `wordpress`.`wp_options` SET `value` = 1;"); table != "wp_options" {
+		t.Errorf("Expected the UPDATE statement's table to be wp_options, got %q", table)
+	}
+}
@@ -1,7 +1,6 @@
 package main
 
 import (
-	"bufio"
 	"encoding/json"
 	"fmt"
 	"github.com/akamensky/argparse"
@@ -10,34 +9,79 @@ import (
 	"io"
 	"io/ioutil"
 	"os"
+	"runtime"
 	"strings"
 	"sync"
 )
 
 type Config struct {
-	Patterns []ConfigPattern `json:"patterns"`
+	Patterns     []ConfigPattern `json:"patterns"`
+	Replacements []Replacement   `json:"replacements"`
+	Subset       *SubsetConfig   `json:"subset"`
+
+	// Key is the secret used to derive replacement values from the original
+	// ones. It's deliberately kept out of the config file and read from the
+	// command line or environment instead.
+	Key []byte `json:"-"`
+
+	// PreserveFormatting writes changed values into the original statements
+	// rather than recompiling them, so everything else is left byte for byte
+	// as it was.
+	PreserveFormatting bool `json:"-"`
+
+	// StreamRows processes the rows of INSERT statements a batch at a time, so
+	// that statements of any size can be processed without holding them in
+	// memory whole.
+	StreamRows bool `json:"-"`
+
+	// Workers is how many statements, or chunks of a statement's rows, are
+	// processed at once, and QueueSize how many statements can be queued up,
+	// processed or waiting to be written out at once. Left at zero, they're
+	// worked out from the number of CPUs.
+	Workers   int `json:"-"`
+	QueueSize int `json:"-"`
+
+	// replacer applies the Replacements. It's built once before processing the
+	// input, rather than for every statement.
+	replacer *searchReplacer
 }
 
 type ConfigPattern struct {
-	TableName string         `json:"tableName"`
-	Fields    []PatternField `json:"fields"`
+	TableName  string                   `json:"tableName"`
+	Action     string                   `json:"action"`
+	DeleteRows []PatternFieldConstraint `json:"deleteRows"`
+	Sample     *SampleConfig            `json:"sample"`
+	Fields     []PatternField           `json:"fields"`
 }
 
 type PatternField struct {
-	Field       string                   `json:"field"`
-	Position    int                      `json:"position"`
-	Type        string                   `json:"type"`
-	Constraints []PatternFieldConstraint `json:"constraints"`
+	Field          string                   `json:"field"`
+	Position       int                      `json:"position"`
+	Type           string                   `json:"type"`
+	Options        TransformationOptions    `json:"options"`
+	ConsistencyKey string                   `json:"consistencyKey"`
+	NullPolicy     string                   `json:"nullPolicy"`
+	Paths          []PathField              `json:"paths"`
+	Constraints    []PatternFieldConstraint `json:"constraints"`
 }
 
 type PatternFieldConstraint struct {
-	Field    string `json:"field"`
-	Position int    `json:"position"`
-	Value    string `json:"value"`
+	Field    string   `json:"field"`
+	Position int      `json:"position"`
+	Operator string   `json:"operator"`
+	Value    string   `json:"value"`
+	Values   []string `json:"values"`
+	Not      bool     `json:"not"`
+
+	// A constraint with any of these set is a group of nested constraints
+	// instead of a comparison against a field
+	All  []PatternFieldConstraint `json:"all"`
+	Any  []PatternFieldConstraint `json:"any"`
+	None []PatternFieldConstraint `json:"none"`
 }
 
 var (
-	transformationFunctionMap = map[string]func(*sqlparser.SQLVal) *sqlparser.SQLVal{
+	transformationFunctionMap = map[string]func(*fakeGenerator, *sqlparser.SQLVal, TransformationOptions) *sqlparser.SQLVal{
 		"username":  generateUsername,
 		"password":  generatePassword,
 		"email":     generateEmail,
@@ -47,9 +91,30 @@ var (
 		"lastName":  generateLastName,
 		"paragraph": generateParagraph,
 		"ipv4":      generateIPv4,
+		"format":    generateFormatted,
+		"mask":      generateMasked,
+		"scramble":  generateScrambled,
+
+		"randomNumber": generateRandomNumber,
+		"noise":        generateNoise,
+		"round":        generateRounded,
+		"date":         generateDate,
 	}
 )
 
+// isTransformationType reports whether fields can be given the type, either
+// because it has a transformation function or because it transforms parts of
+// the value, such as values nested inside structured data.
+func isTransformationType(transformation string) bool {
+	return transformationFunctionMap[transformation] != nil || isStructuredType(transformation) || transformation == scrubType
+}
+
+// isStructuredType reports whether the type transforms values found at paths
+// inside the field rather than the field as a whole.
+func isStructuredType(transformation string) bool {
+	return transformation == serializedType || transformation == jsonType
+}
+
 // Many thanks to https://stackoverflow.com/a/47515580/1454045
 func init() {
 	lvl, ok := os.LookupEnv("LOG_LEVEL")
@@ -69,33 +134,63 @@ func init() {
 func main() {
 	config := parseArgs()
 
-	lines := setupAndProcessInput(config, os.Stdin)
+	output := setupAndProcessInput(config, os.Stdin)
 
-	for line := range lines {
-		fmt.Print(<-line)
+	for line := range output.lines {
+		fmt.Print(line)
+	}
+	if err := output.err(); err != nil {
+		// Everything up to the statement that failed has been written out, but
+		// the dump is incomplete, so make sure that doesn't go unnoticed
+		logrus.Fatal(err)
 	}
 }
 
-func setupAndProcessInput(config Config, input io.Reader) chan chan string {
-	var wg sync.WaitGroup
-	lines := make(chan chan string, 10)
+func setupAndProcessInput(config Config, input io.Reader) *pipeline {
+	// Subsetting has to read through the whole dump first to work out which rows
+	// to keep
+	if config.Subset != nil {
+		var err error
+		input, err = config.Subset.prepare(input, config.StreamRows)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error": err,
+			}).Fatal("Failed working out the rows to keep for the subset")
+		}
+	}
 
-	wg.Add(1)
-	go processInput(&wg, input, lines, config)
+	if config.Workers < 1 {
+		config.Workers = runtime.NumCPU()
+	}
+	config.replacer = newSearchReplacer(config.Replacements)
+	queueSize := config.QueueSize
+	if queueSize < 1 {
+		queueSize = 4 * config.Workers
+	}
+	output := newPipeline(config.Workers, queueSize)
 
 	go func() {
-		wg.Wait()
-		close(lines)
+		processInput(input, output, config, newTableSchemas(), newPseudonymizer(config.Key))
+		output.close()
 	}()
 
-	return lines
+	return output
 }
 
 func parseArgs() Config {
 	parser := argparse.NewParser("anonymize-mysqldump", "Reads SQL from STDIN and replaces content for anonymity based on the provided config.")
 	configFilePath := parser.String("c", "config", &argparse.Options{Required: true, Help: "Path to config.json"})
+	key := parser.String("k", "key", &argparse.Options{Help: "Secret key used to replace each value with the same fake value every time. Can also be set with the " + keyEnvVar + " environment variable"})
+	keyFilePath := parser.String("", "key-file", &argparse.Options{Help: "Path to a file containing the secret key"})
+	preserveFormatting := parser.Flag("", "preserve-formatting", &argparse.Options{Help: "Only change the values being replaced, keeping the rest of each INSERT statement exactly as it was"})
+	streamRows := parser.Flag("", "stream-rows", &argparse.Options{Help: "Process the rows of large INSERT statements a batch at a time, writing each batch out as a statement of its own"})
+	workers := parser.Int("", "workers", &argparse.Options{Help: "Number of statements, or chunks of a large statement's rows, to process at once. Defaults to the number of CPUs"})
+	queueSize := parser.Int("", "queue-size", &argparse.Options{Help: "Number of statements that can be queued up, processed or waiting to be written out at once, capping memory use. Defaults to four times the number of workers"})
 
 	err := parser.Parse(os.Args)
+	if err == nil && (*workers < 0 || *queueSize < 0) {
+		err = fmt.Errorf("--workers and --queue-size can't be negative")
+	}
 	if err != nil {
 		// In case of error print error and print usage
 		// This can also be done by passing -h or --help flags
@@ -103,7 +198,17 @@ func parseArgs() Config {
 		os.Exit(1)
 	}
 
-	return readConfigFile(*configFilePath)
+	config := readConfigFile(*configFilePath)
+	config.Key, err = readKey(*key, *keyFilePath)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	config.PreserveFormatting = *preserveFormatting
+	config.StreamRows = *streamRows
+	config.Workers = *workers
+	config.QueueSize = *queueSize
+
+	return config
 }
 
 func readConfigFile(filepath string) Config {
@@ -116,108 +221,291 @@ func readConfigFile(filepath string) Config {
 	jsonReader := strings.NewReader(string(jsonConfig))
 	jsonParser := json.NewDecoder(jsonReader)
 	jsonParser.Decode(&decoded)
+
+	for _, pattern := range decoded.Patterns {
+		if err := pattern.validate(); err != nil {
+			logrus.WithFields(logrus.Fields{
+				"table": pattern.TableName,
+			}).Fatal(err)
+		}
+		for _, fieldPattern := range pattern.Fields {
+			if err := fieldPattern.validate(); err != nil {
+				logrus.WithFields(logrus.Fields{
+					"table": pattern.TableName,
+					"field": fieldPattern.Field,
+				}).Fatal(err)
+			}
+		}
+	}
+	if decoded.Subset != nil {
+		if err := decoded.Subset.validate(); err != nil {
+			logrus.Fatal(err)
+		}
+	}
+	for _, replacement := range decoded.Replacements {
+		if err := replacement.validate(); err != nil {
+			logrus.WithFields(logrus.Fields{
+				"replace": replacement.Replace,
+			}).Fatal(err)
+		}
+	}
+
 	return decoded
 }
 
-func processInput(wg *sync.WaitGroup, input io.Reader, lines chan chan string, config Config) {
-	defer wg.Done()
+// validate checks the table's settings, leaving its fields to be checked
+// separately.
+func (p ConfigPattern) validate() error {
+	if err := validateAction(p.Action); err != nil {
+		return err
+	}
+	for _, constraint := range p.DeleteRows {
+		if err := constraint.validate(); err != nil {
+			return err
+		}
+	}
+	if p.Sample != nil {
+		return p.Sample.validate()
+	}
+	return nil
+}
 
-	r := bufio.NewReaderSize(input, 2*1024*1024)
-	var nextLine string
-	insertStarted := false
-	continueLooping := true
-	for continueLooping {
-		line, err := r.ReadString('\n')
+// validate checks the field's settings, so that mistakes in the config are
+// reported before any data is processed.
+func (f PatternField) validate() error {
+	if err := validateNullPolicy(f.NullPolicy); err != nil {
+		return err
+	}
+	if err := f.Options.validate(); err != nil {
+		return err
+	}
+	if f.Type == "format" && f.Options.Format == "" {
+		return fmt.Errorf("format type requires a format option")
+	}
+	if isStructuredType(f.Type) {
+		if len(f.Paths) == 0 {
+			return fmt.Errorf("%s type requires paths", f.Type)
+		}
+		if f.ConsistencyKey != "" {
+			return fmt.Errorf("%s type can't have a consistency key, set it on its paths instead", f.Type)
+		}
+	}
+	for _, pathField := range f.Paths {
+		if err := pathField.patternField(f).validate(); err != nil {
+			return err
+		}
+		if !isTransformationType(pathField.Type) || isStructuredType(pathField.Type) {
+			return fmt.Errorf("unknown type %q for path %s", pathField.Type, pathField.Path)
+		}
+		if f.Type == jsonType {
+			if _, err := parseJSONPath(pathField.Path); err != nil {
+				return err
+			}
+		}
+	}
+	for _, constraint := range f.Constraints {
+		if err := constraint.validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
+func processInput(input io.Reader, output *pipeline, config Config, schemas *TableSchemas, pseudonymizer *Pseudonymizer) {
+
+	// Free text can only have the names replaced elsewhere in the dump swapped
+	// out once those replacements have been made, so statements for tables
+	// scrubbed using the dictionary wait for the statements before them
+	dictionaryTables := config.tablesUsingDictionary()
+	pseudonymizer.dictionary = len(dictionaryTables) > 0
+
+	actions := config.tableActions()
+	// lockedTable is the table named by the last LOCK TABLES statement, which the
+	// next UNLOCK TABLES statement belongs to
+	var lockedTable string
+
+	// Sampling every Nth row counts the rows of each table in the order they
+	// appear in the dump
+	counter := newRowCounter()
+	// framing holds the chunks around a table's statements until we know whether
+	// the table is skipped
+	var framing []string
+	// statements counts the statements processed so far
+	var statements int64
+
+	scanner := newSQLScanner(input)
+	scanner.streamRows = config.StreamRows
+	// There's no point reading any further once a statement has failed, as
+	// nothing after it is written out
+	for !output.failed() {
+		chunk, err := scanner.next()
 		if err == io.EOF {
-			// continueLooping is used because line might be populated even when we've
-			// reached the end of the file, so we set a boolean once the last line is
-			// being processed to end the loop.
-			continueLooping = false
-		} else if err != nil {
-			// log any other errors and break
-			logrus.Error(err.Error())
 			break
+		} else if err != nil {
+			output.stop(fmt.Errorf("failed reading input: %v", err))
+			return
 		}
 
-		// If the line is shorter than 6 characters, which is the shortest line for
-		// an insert query, let's skip processing it
-		if len(line) < 6 {
+		// Keep track of CREATE TABLE statements so that fields can be matched to
+		// columns by name once the table's INSERT statements come through. The
+		// statements are still passed through untouched below.
+		if chunk.kind == chunkStatement && createTablePattern.MatchString(chunk.text) {
+			err := schemas.addFromCreateTable(chunk.text)
+			if err != nil {
+				logrus.WithFields(logrus.Fields{
+					"error": err,
+					"query": chunk.text,
+				}).Warn("Failed reading columns from CREATE TABLE statement, falling back to configured positions")
+			}
+		}
 
-			// TODO I'd love to clean this up so we don't make ch in three different
-			// places, but that's a task for another day
-			ch := make(chan string)
-			lines <- ch
-			ch <- line
-			//ch <- line + "\n"
-			continue
+		var table string
+		isInsert, isUpdate := false, false
+		switch chunk.kind {
+		case chunkInsertHeader:
+			table = insertTableName(chunk.text)
+			isInsert = true
+		case chunkStatement:
+			if table = insertTableName(chunk.text); table != "" {
+				isInsert = true
+				break
+			}
+			if table = updateTableName(chunk.text); table != "" {
+				isUpdate = true
+				break
+			}
+			table = tableStatementName(chunk.text)
+			if lockTablesPattern.MatchString(chunk.text) {
+				lockedTable = table
+			}
+			if unlockTablesPattern.MatchString(chunk.text) {
+				table = lockedTable
+				lockedTable = ""
+			}
+		case chunkComment:
+			table = tableStatementName(chunk.text)
 		}
 
-		// Test if this is an INSERT query. We'll use this to determine if we need
-		// to concatenate lines together if they're spread apart multiple lines
-		// instead of on a single line
-		maybeInsert := strings.ToUpper(line[:6]) == "INSERT"
-		if maybeInsert {
-			insertStarted = true
+		// Skipped tables leave no trace in the output, so every statement and
+		// comment about them is dropped, along with the lines framing them
+		if actions[table] == actionSkip {
+			framing = nil
+			scanner.skipRows(chunk)
+			continue
 		}
+		if len(actions) > 0 && isFramingLine(strings.TrimSpace(chunk.text)) {
+			framing = append(framing, chunk.text)
+			continue
+		}
+		for _, framingText := range framing {
+			output.passThrough(framingText)
+		}
+		framing = nil
 
-		line = strings.TrimSpace(line)
-		// Now that we've detected this is an INSERT query, let's append the lines
-		// together to form a single line in the event this spans multiple lines
-		if insertStarted {
-			nextLine += line
-		} else {
-			// When it's not an insert query, let's add this line and move on without
-			// processing it
-			// TODO clean this up too
-			ch := make(chan string)
-			lines <- ch
-			ch <- line + "\n"
+		if !isInsert && !isUpdate {
+			// Everything but INSERT and UPDATE statements is passed through as it is
+			output.passThrough(chunk.text)
 			continue
 		}
 
-		lastCharacter := line[len(line)-1:]
-		if lastCharacter == ";" {
-			insertStarted = false
-		} else {
-			// If we haven't reached a query terminator and and insert query has
-			// begun, let's move on to the next line
+		// The data of truncated tables is dropped without even being parsed
+		if actions[table] == actionTruncate {
+			scanner.skipRows(chunk)
 			continue
 		}
 
-		// Now let's actually process the line!
-		wg.Add(1)
-		ch := make(chan string)
-		lines <- ch
-		go func(line string) {
-			defer wg.Done()
-			line = processLine(line, config)
-			ch <- line
-		}(nextLine)
+		process := func(statement string) {
+			if dictionaryTables[table] {
+				output.wait()
+			}
+
+			// The fake values are seeded from the statement's position in the
+			// input, so they don't depend on how many statements are processed at
+			// once
+			statementPseudonymizer := pseudonymizer.forStatement(statements)
+			statements++
 
-		// Now let's reset nextLine to empty so that it doesn't continue
-		// appending lines forever
-		nextLine = ""
+			var offset *rowOffset
+			if sample := config.sampleFor(table); isInsert && sample != nil && sample.Every > 0 {
+				offset = counter.next(table)
+			}
+
+			// Now let's actually process the statement!
+			output.process(func() (string, error) {
+				defer offset.release()
+				return processLine(statement, config, schemas, statementPseudonymizer, offset)
+			})
+		}
+
+		if chunk.kind != chunkInsertHeader {
+			process(chunk.text)
+			continue
+		}
+		// Each batch of rows is processed as a statement of its own, so only a
+		// few batches are held in memory at once however big the statement is
+		err = scanner.batches(chunk.text, func(batch string) error {
+			process(batch)
+			return nil
+		})
+		if err != nil {
+			output.stop(err)
+			return
+		}
 	}
 
+	for _, framingText := range framing {
+		output.passThrough(framingText)
+	}
 }
 
-func processLine(line string, config Config) string {
+// processLine returns the statement with the config applied to it. It only
+// returns an error when carrying on would mean writing out data we were asked
+// to anonymize.
+func processLine(line string, config Config, schemas *TableSchemas, pseudonymizer *Pseudonymizer, offset *rowOffset) (string, error) {
 
 	parsed, err := parseLine(line)
 	if err != nil {
-		// TODO Add line number to log
+		table := insertTableName(line)
+		if table == "" {
+			table = updateTableName(line)
+		}
+		// Passing a statement we were asked to change through untouched could
+		// leak the very data it was meant to anonymize
+		if config.modifiesTable(table) {
+			return "", fmt.Errorf("failed parsing statement for table %s: %v", table, err)
+		}
+		// The statement itself isn't logged, as it could hold anything
 		logrus.WithFields(logrus.Fields{
 			"error": err,
-			"line":  line,
+			"table": table,
 		}).Error("Failed parsing line with error: ")
-		return line
+		return line, nil
+	}
+
+	var snapshot *insertSnapshot
+	if config.PreserveFormatting {
+		snapshot = snapshotInsert(parsed)
 	}
 
 	// TODO Detect if line matches pattern
-	processed, err := applyConfigToParsedLine(parsed, config)
+	processed, err := applyConfigToParsedLine(parsed, config, schemas, pseudonymizer, offset)
+	if err != nil {
+		// Carrying on would mean writing out data we were asked to anonymize, so
+		// bail out instead
+		return "", fmt.Errorf("failed applying config to line: %v", err)
+	}
+	if processed == nil {
+		// Every row was deleted
+		return "", nil
+	}
 	// TODO make modifications
 
+	if snapshot != nil {
+		if spliced, ok := snapshot.splice(line, processed); ok {
+			return spliced, nil
+		}
+	}
+
 	// TODO Return changes
 	recompiled, err := recompileStatementToSQL(processed)
 	if err != nil {
@@ -225,9 +513,34 @@ func processLine(line string, config Config) string {
 		logrus.WithFields(logrus.Fields{
 			"error": err,
 		}).Error("Failed recompiling line with error: ")
-		return line
+		return line, nil
+	}
+	return recompiled, nil
+}
+
+// modifiesTable reports whether the config changes the table's data, whether
+// by anonymizing, deleting, sampling or subsetting its rows or searching and
+// replacing in them.
+func (c Config) modifiesTable(table string) bool {
+	if c.replacer != nil {
+		return true
+	}
+	for _, pattern := range c.Patterns {
+		if pattern.TableName == table {
+			return true
+		}
+	}
+	if c.Subset != nil {
+		if c.Subset.Root.TableName == table {
+			return true
+		}
+		for _, relationship := range c.Subset.Relationships {
+			if relationship.TableName == table {
+				return true
+			}
+		}
 	}
-	return recompiled
+	return false
 }
 
 func parseLine(line string) (sqlparser.Statement, error) {
@@ -239,23 +552,30 @@ func parseLine(line string) (sqlparser.Statement, error) {
 	return stmt, nil
 }
 
-func applyConfigToParsedLine(stmt sqlparser.Statement, config Config) (sqlparser.Statement, error) {
+func applyConfigToParsedLine(stmt sqlparser.Statement, config Config, schemas *TableSchemas, pseudonymizer *Pseudonymizer, offset *rowOffset) (sqlparser.Statement, error) {
+
+	if update, isUpdateStatement := stmt.(*sqlparser.Update); isUpdateStatement {
+		return applyConfigToUpdate(update, config, schemas, pseudonymizer)
+	}
 
 	insert, isInsertStatement := stmt.(*sqlparser.Insert)
 	if !isInsertStatement {
-		// Let's skip other statements as we only want to process inserts.
+		// Let's skip other statements as we only want to process inserts and
+		// updates.
 		return stmt, nil
 	}
 
-	modified, err := applyConfigToInserts(insert, config)
+	modified, err := applyConfigToInserts(insert, config, schemas, pseudonymizer, offset)
 	if err != nil {
-		// TODO Log error and move on
-		return stmt, nil
+		return stmt, err
+	}
+	if modified == nil {
+		return nil, nil
 	}
 	return modified, nil
 }
 
-func applyConfigToInserts(stmt *sqlparser.Insert, config Config) (*sqlparser.Insert, error) {
+func applyConfigToInserts(stmt *sqlparser.Insert, config Config, schemas *TableSchemas, pseudonymizer *Pseudonymizer, offset *rowOffset) (*sqlparser.Insert, error) {
 
 	values, isValuesSlice := stmt.Rows.(sqlparser.Values)
 	if !isValuesSlice {
@@ -264,42 +584,170 @@ func applyConfigToInserts(stmt *sqlparser.Insert, config Config) (*sqlparser.Ins
 		return stmt, nil
 	}
 
+	// Work out which column is which. An explicit column list on the INSERT
+	// (mysqldump --complete-insert) is the most reliable source, followed by
+	// the table's CREATE TABLE statement.
+	table := stmt.Table.Name.String()
+	completeInsert := len(stmt.Columns) > 0
+	var columns map[string]int
+	if completeInsert {
+		columns = columnsFromInsert(stmt.Columns)
+	} else {
+		columns = schemas.columnsFor(table)
+	}
+
+	// Rows are sampled as they appear in the dump, before any are dropped for
+	// other reasons
+	if sample := config.sampleFor(table); sample != nil {
+		kept, err := sample.keepRows(table, values, columns, offset)
+		if err != nil {
+			return stmt, err
+		}
+		if len(kept) == 0 {
+			return nil, nil
+		}
+		values = kept
+		stmt.Rows = values
+	}
+
+	if config.Subset != nil {
+		kept, err := config.Subset.keepRows(table, values, columns)
+		if err != nil {
+			return stmt, err
+		}
+		if len(kept) == 0 {
+			// None of the rows are part of the subset
+			return nil, nil
+		}
+		values = kept
+		stmt.Rows = values
+	}
+
 	// Iterate over the specified configs and see if this statement matches any
 	// of the desired changes
 	// TODO make this use goroutines
 	for _, pattern := range config.Patterns {
-		if stmt.Table.Name.String() != pattern.TableName {
+		if table != pattern.TableName {
 			// Config is not for this table, move onto next available config
 			continue
 		}
 
+		if completeInsert {
+			// The INSERT tells us exactly which column is where, so a position that
+			// disagrees with it means the config can't be trusted for this table
+			if err := findStalePosition(pattern, columns); err != nil {
+				return stmt, err
+			}
+		} else {
+			if err := findStalePosition(pattern, columns); err != nil {
+				logrus.WithFields(logrus.Fields{
+					"error": err,
+				}).Warn("Configured position doesn't match the table's columns, matching by field name instead")
+			}
+		}
+
+		// Rows that are deleted don't need anonymizing, so get rid of them first
+		if len(pattern.DeleteRows) > 0 {
+			values = deleteRows(values, pattern.DeleteRows, columns)
+			if len(values) == 0 {
+				// Nothing's left to insert, so leave out the statement altogether
+				return nil, nil
+			}
+		}
+
 		// Ok, now it's time to make some modifications
-		newValues, err := modifyValues(values, pattern)
+		newValues, err := modifyValues(values, pattern, columns, pseudonymizer, config.Workers)
 		if err != nil {
-			// TODO Perhaps worth logging when this happens?
-			return stmt, nil
+			return stmt, err
 		}
 		stmt.Rows = newValues
+		modifyOnDup(stmt.OnDup, values, pattern, columns, pseudonymizer)
+	}
+
+	// Search and replace runs on every table once the values have been
+	// anonymized, so constraints still see the original values
+	if replacer := config.replacer; replacer != nil {
+		applyReplacements(values, replacer)
+		replaceAssignments(sqlparser.UpdateExprs(stmt.OnDup), replacer)
 	}
 
 	return stmt, nil
 }
 
-// TODO we're gonna have to figure out how to retain types if we ever want to
-// mask number-based fields
-func modifyValues(values sqlparser.Values, pattern ConfigPattern) (sqlparser.Values, error) {
+// rowChunkSize is how many rows of a statement are transformed together.
+const rowChunkSize = 256
+
+// modifyValues transforms the configured fields of every row. Statements with
+// lots of rows have them transformed a chunk at a time, with as many chunks as
+// there are workers being transformed at once.
+func modifyValues(values sqlparser.Values, pattern ConfigPattern, columns map[string]int, pseudonymizer *Pseudonymizer, workers int) (sqlparser.Values, error) {
+
+	// Work out which column each field refers to once for the whole statement
+	// instead of once per row
+	valTupleIndexes := make([]int, len(pattern.Fields))
+	for i, fieldPattern := range pattern.Fields {
+		valTupleIndex, err := resolveColumnIndex(fieldPattern.Field, fieldPattern.Position, columns)
+		if err != nil && columns == nil {
+			// Without the table's columns the field's value is in the rows
+			// somewhere, and would be written out as it was
+			return values, fmt.Errorf("can't find column %s of %s to anonymize: %s", fieldPattern.Field, pattern.TableName, err)
+		}
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error": err,
+				"table": pattern.TableName,
+				"field": fieldPattern.Field,
+			}).Error("Failed finding column for field")
+		}
+		valTupleIndexes[i] = valTupleIndex
+	}
 
-	// TODO make this use goroutines
-	for row := range values {
-		// TODO make this use goroutines
-		for _, fieldPattern := range pattern.Fields {
-			// Position is 1 indexed instead of 0, so let's subtract 1 in order to get
-			// it to line up with the value inside the ValTuple inside of values.Values
-			valTupleIndex := fieldPattern.Position - 1
-			value := values[row][valTupleIndex].(*sqlparser.SQLVal)
+	// Free text scrubbed using the dictionary can mention the names replaced in
+	// the rows before it, so those rows are always transformed in order
+	if len(values) <= rowChunkSize || pattern.usesDictionary() {
+		modifyRows(values, pattern, valTupleIndexes, columns, pseudonymizer)
+		return values, nil
+	}
+
+	// The rows are split into the same chunks however many workers there are,
+	// so that the replacements are the same too
+	chunks := pseudonymizer.split((len(values) + rowChunkSize - 1) / rowChunkSize)
+	if workers < 1 {
+		workers = 1
+	}
+	var wg sync.WaitGroup
+	running := make(chan struct{}, workers)
+	for i, chunk := range chunks {
+		end := (i + 1) * rowChunkSize
+		if end > len(values) {
+			end = len(values)
+		}
+		wg.Add(1)
+		running <- struct{}{}
+		go func(rows sqlparser.Values, chunk *Pseudonymizer) {
+			defer wg.Done()
+			modifyRows(rows, pattern, valTupleIndexes, columns, chunk)
+			<-running
+		}(values[i*rowChunkSize:end], chunk)
+	}
+	wg.Wait()
+	pseudonymizer.merge(chunks)
+
+	// values[0][0] = sqlparser.NewStrVal([]byte("Foobar"))
+	return values, nil
+}
 
+// modifyRows transforms the configured fields of each of the rows, given the
+// column each field refers to.
+func modifyRows(values sqlparser.Values, pattern ConfigPattern, valTupleIndexes []int, columns map[string]int, pseudonymizer *Pseudonymizer) {
+	for row := range values {
+		for i, fieldPattern := range pattern.Fields {
+			valTupleIndex := valTupleIndexes[i]
+			if valTupleIndex < 0 || valTupleIndex >= len(values[row]) {
+				continue
+			}
 			// Skip transformation if transforming function doesn't exist
-			if transformationFunctionMap[fieldPattern.Type] == nil {
+			if !isTransformationType(fieldPattern.Type) {
 				// TODO in the event a transformation function isn't correctly defined,
 				// should we actually exit? Should we exit or fail softly whenever
 				// something goes wrong in general?
@@ -310,40 +758,90 @@ func modifyValues(values sqlparser.Values, pattern ConfigPattern) (sqlparser.Val
 				continue
 			}
 
-			// Skipping applying a transformation because field is empty
-			if len(value.Val) == 0 {
+			// Skip this PatternField if none of its constraints match
+			if fieldPattern.Constraints != nil && !rowMatchesAll(fieldPattern.Constraints, values[row], columns) {
 				continue
 			}
 
-			// Skip this PatternField if none of its constraints match
-			if fieldPattern.Constraints != nil && !rowObeysConstraints(fieldPattern.Constraints, values[row]) {
+			value, replacement := valueToTransform(values[row][valTupleIndex], fieldPattern)
+			if replacement != nil {
+				values[row][valTupleIndex] = replacement
+			}
+			if value == nil {
 				continue
 			}
 
-			values[row][valTupleIndex] = transformationFunctionMap[fieldPattern.Type](value)
+			values[row][valTupleIndex] = pseudonymizer.transform(fieldPattern, value)
 		}
+	}
+}
 
+// modifyOnDup anonymizes the values an ON DUPLICATE KEY UPDATE clause assigns
+// to configured fields. The assignments apply to whichever existing rows the
+// inserted ones collide with, so a field with constraints is anonymized when
+// any of the inserted rows match them.
+func modifyOnDup(onDup sqlparser.OnDup, values sqlparser.Values, pattern ConfigPattern, columns map[string]int, pseudonymizer *Pseudonymizer) {
+	modifyAssignments(sqlparser.UpdateExprs(onDup), pattern, columns, pseudonymizer, func(constraints []PatternFieldConstraint) bool {
+		return anyRowMatchesAll(constraints, values, columns)
+	})
+}
+
+// modifyAssignments anonymizes the values assigned to configured fields, using
+// matches to decide whether a field's constraints are met.
+func modifyAssignments(assignments sqlparser.UpdateExprs, pattern ConfigPattern, columns map[string]int, pseudonymizer *Pseudonymizer, matches func([]PatternFieldConstraint) bool) {
+	for _, assignment := range assignments {
+		if _, ok := assignment.Expr.(*sqlparser.ValuesFuncExpr); ok {
+			// VALUES(column) refers to the inserted value, which has already been
+			// anonymized
+			continue
+		}
+
+		column := assignment.Name.Name.Lowered()
+		for _, fieldPattern := range pattern.Fields {
+			if !assignsField(column, fieldPattern, columns) || !isTransformationType(fieldPattern.Type) {
+				continue
+			}
+			if fieldPattern.Constraints != nil && !matches(fieldPattern.Constraints) {
+				continue
+			}
+			assignment.Expr = transformExpr(assignment.Expr, fieldPattern, pseudonymizer)
+		}
 	}
+}
 
-	// values[0][0] = sqlparser.NewStrVal([]byte("Foobar"))
-	return values, nil
+// replaceAssignments searches and replaces the values assigned to any column.
+func replaceAssignments(assignments sqlparser.UpdateExprs, replacer *searchReplacer) {
+	assigned := make(sqlparser.ValTuple, len(assignments))
+	for i, assignment := range assignments {
+		assigned[i] = assignment.Expr
+	}
+	applyReplacements(sqlparser.Values{assigned}, replacer)
+	for i, assignment := range assignments {
+		assignment.Expr = assigned[i]
+	}
 }
 
-func rowObeysConstraints(constraints []PatternFieldConstraint, row sqlparser.ValTuple) bool {
-	for _, constraint := range constraints {
-		valTupleIndex := constraint.Position - 1
-		value := row[valTupleIndex].(*sqlparser.SQLVal)
+// assignsField reports whether a column named in an assignment is the one the
+// field refers to.
+func assignsField(column string, fieldPattern PatternField, columns map[string]int) bool {
+	if strings.EqualFold(column, fieldPattern.Field) {
+		return true
+	}
+	index, ok := columns[column]
+	if !ok {
+		return false
+	}
+	fieldIndex, err := resolveColumnIndex(fieldPattern.Field, fieldPattern.Position, columns)
+	return err == nil && fieldIndex == index
+}
 
-		parsedValue := convertSQLValToString(value)
-		logrus.WithFields(logrus.Fields{
-			"parsedValue":      parsedValue,
-			"constraint.value": constraint.Value,
-		}).Trace("Debuging constraint obediance: ")
-		if parsedValue != constraint.Value {
-			return false
+func anyRowMatchesAll(constraints []PatternFieldConstraint, values sqlparser.Values, columns map[string]int) bool {
+	for _, row := range values {
+		if rowMatchesAll(constraints, row, columns) {
+			return true
 		}
 	}
-	return true
+	return false
 }
 
 func convertSQLValToString(value *sqlparser.SQLVal) string {
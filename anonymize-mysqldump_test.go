@@ -1,7 +1,9 @@
 package main
 
 import (
-	"bytes"
+	"fmt"
+	"github.com/xwb1989/sqlparser"
+	"strings"
 	"syreclabs.com/go/faker"
 	"testing"
 )
@@ -28,13 +30,13 @@ var (
 	(3,1,'foobar','bazquz'),
 	(4,1,'nickname','Jim'),
 	(5,1,'description','Lorum ipsum.');`
-	multilineQueryRecompiled = "insert into wp_usermeta values (1, 1, 'first_name', 'Jazmyn'), (2, 1, 'last_name', 'Reynolds'), (3, 1, 'foobar', 'bazquz'), (4, 1, 'nickname', 'Sherman'), (5, 1, 'description', 'Vel at et.');\n"
+	multilineQueryRecompiled = "insert into wp_usermeta values (1, 1, 'first_name', 'Sallie'), (2, 1, 'last_name', 'Goldner'), (3, 1, 'foobar', 'bazquz'), (4, 1, 'nickname', 'Judy'), (5, 1, 'description', 'Fuga et odio.');\n"
 	commentsQuery            = "INSERT INTO `wp_comments` VALUES (1,1,'A WordPress Commenter','wapuu@wordpress.example','https://wordpress.org/','','2019-06-12 00:59:19','2019-06-12 00:59:19','Hi, this is a comment.\\nTo get started with moderating, editing, and deleting comments, please visit the Comments screen in the dashboard.\\nCommenter avatars come from <a href=\\\"https://gravatar.com\\\">Gravatar</a>.',0,'1','','',0,0);\n"
-	commentsQueryRecompiled  = "insert into wp_comments values (1, 1, 'kamren.ohara', 'michele_barton@example.net', 'http://ebert.com/korey_keeling', '', '2019-06-12 00:59:19', '2019-06-12 00:59:19', 'Hi, this is a comment.\\nTo get started with moderating, editing, and deleting comments, please visit the Comments screen in the dashboard.\\nCommenter avatars come from <a href=\\\"https://gravatar.com\\\">Gravatar</a>.', 0, '1', '', '', 0, 0);\n"
+	commentsQueryRecompiled  = "insert into wp_comments values (1, 1, 'kendrick', 'amanda@example.com', 'http://effertzondricka.name/carolanne.turner', '', '2019-06-12 00:59:19', '2019-06-12 00:59:19', 'Hi, this is a comment.\\nTo get started with moderating, editing, and deleting comments, please visit the Comments screen in the dashboard.\\nCommenter avatars come from <a href=\\\"https://gravatar.com\\\">Gravatar</a>.', 0, '1', '', '', 0, 0);\n"
 	usersQuery               = "INSERT INTO `wp_users` VALUES (1,'username','user_pass','username','hosting@humanmade.com','','2019-06-12 00:59:19','',0,'username'),(2,'username','user_pass','username','hosting@humanmade.com','http://notreal.com/username','2019-06-12 00:59:19','',0,'username');\n"
-	usersQueryRecompiled     = "insert into wp_users values (1, 'treva_cremin', 'NjaK5HeMAMuv', 'hailey', 'bernice.heaney@example.net', '', '2019-06-12 00:59:19', '', 0, 'Kylie Rice'), (2, 'eduardo', 'J3JRQ4XoIxXX6A', 'albert.okeefe', 'brooke.hayes@example.net', 'http://pfannerstill.net/brando', '2019-06-12 00:59:19', '', 0, 'Ardella Jenkins PhD');\n"
+	usersQueryRecompiled     = "insert into wp_users values (1, 'elinor', 'ai5nyaqJS', 'selmer_ondricka', 'rahsaan_nolan@example.com', '', '2019-06-12 00:59:19', '', 0, 'Johnathan Leffler Jr.'), (2, 'ralph', '3GhTFxStua', 'rita.spinka', 'vincenza_kuphal@example.net', 'http://wildermanwalker.name/rachelle.oconnell', '2019-06-12 00:59:19', '', 0, 'Michel Friesen IV');\n"
 	userMetaQuery            = "INSERT INTO `wp_usermeta` VALUES (1,1,'first_name','John'),(2,1,'last_name','Doe'),(3,1,'foobar','bazquz'),(4,1,'nickname','Jim'),(5,1,'description','Lorum ipsum.'),(6,2,'first_name','Janet'),(7,2,'last_name','Doe'),(8,2,'foobar','bazquz'),(9,2,'nickname','Jane'),(10,2,'description','Lorum ipsum.');\n"
-	userMetaQueryRecompiled  = "insert into wp_usermeta values (1, 1, 'first_name', 'Stephania'), (2, 1, 'last_name', 'Hamill'), (3, 1, 'foobar', 'bazquz'), (4, 1, 'nickname', 'Noah'), (5, 1, 'description', 'Dolorum nostrum alias.'), (6, 2, 'first_name', 'Ed'), (7, 2, 'last_name', 'Koelpin'), (8, 2, 'foobar', 'bazquz'), (9, 2, 'nickname', 'Watson'), (10, 2, 'description', 'Qui voluptatum est.');\n"
+	userMetaQueryRecompiled  = "insert into wp_usermeta values (1, 1, 'first_name', 'Oliver'), (2, 1, 'last_name', 'Zboncak'), (3, 1, 'foobar', 'bazquz'), (4, 1, 'nickname', 'Creola'), (5, 1, 'description', 'Voluptas sunt reiciendis.'), (6, 2, 'first_name', 'Abigail'), (7, 2, 'last_name', 'Prohaska'), (8, 2, 'foobar', 'bazquz'), (9, 2, 'nickname', 'Pasquale'), (10, 2, 'description', 'Quia vel voluptas.');\n"
 )
 
 func init() {
@@ -42,15 +44,33 @@ func init() {
 	jsonConfig = readConfigFile("./config.example.json")
 }
 
+// processString runs the input through the whole pipeline, returning the
+// output as one string.
+func processString(t *testing.T, config Config, input string) string {
+	t.Helper()
+
+	output := setupAndProcessInput(config, strings.NewReader(input))
+
+	var result string
+	for line := range output.lines {
+		result += line
+	}
+	if err := output.err(); err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
 func BenchmarkProcessLine(b *testing.B) {
 	for i := 0; i < b.N; i++ {
-		processLine(usersQuery, jsonConfig)
-		processLine(userMetaQuery, jsonConfig)
-		processLine(commentsQuery, jsonConfig)
+		processLine(usersQuery, jsonConfig, nil, newPseudonymizer(nil), nil)
+		processLine(userMetaQuery, jsonConfig, nil, newPseudonymizer(nil), nil)
+		processLine(commentsQuery, jsonConfig, nil, newPseudonymizer(nil), nil)
 	}
 }
 
 func TestSetupAndProcessInput(t *testing.T) {
+	faker.Seed(432)
 
 	var tests = []struct {
 		testName string
@@ -80,20 +100,341 @@ func TestSetupAndProcessInput(t *testing.T) {
 		{
 			testName: "table creation",
 			query:    dropAndCreateTable,
-			wants:    dropAndCreateTable + "\n",
+			wants:    dropAndCreateTable,
 		},
 	}
 
 	for _, test := range tests {
 		t.Run(test.testName, func(t *testing.T) {
 
-			input := bytes.NewBufferString(test.query)
-			lines := setupAndProcessInput(jsonConfig, input)
+			result := processString(t, jsonConfig, test.query)
 
-			var result string
-			for line := range lines {
-				result += <-line
+			if result != test.wants {
+				t.Error("\nExpected:\n", test.wants, "\nActual:\n", result)
 			}
+		})
+	}
+}
+
+func TestFieldsResolvedByColumnName(t *testing.T) {
+	faker.Seed(432)
+
+	config := Config{
+		Patterns: []ConfigPattern{
+			{
+				TableName: "wp_users",
+				Fields: []PatternField{
+					{
+						Field: "user_email",
+						Type:  "email",
+					},
+					{
+						// Stale positions are ignored in favour of the column name
+						Field:    "display_name",
+						Position: 2,
+						Type:     "name",
+						Constraints: []PatternFieldConstraint{
+							{
+								Field: "user_status",
+								Value: "0",
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	query := "CREATE TABLE `wp_users` (\n" +
+		"`ID` bigint(20) unsigned NOT NULL AUTO_INCREMENT,\n" +
+		"`user_login` varchar(60) NOT NULL DEFAULT '',\n" +
+		"`user_status` int(11) NOT NULL DEFAULT '0',\n" +
+		"`user_email` varchar(100) NOT NULL DEFAULT '',\n" +
+		"`display_name` varchar(250) NOT NULL DEFAULT '',\n" +
+		"PRIMARY KEY (`ID`)\n" +
+		") ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;\n" +
+		"INSERT INTO `wp_users` VALUES (1,'admin',0,'admin@example.com','Admin'),(2,'spammer',1,'spam@example.com','Spammer');\n"
+	wants := "CREATE TABLE `wp_users` (\n" +
+		"`ID` bigint(20) unsigned NOT NULL AUTO_INCREMENT,\n" +
+		"`user_login` varchar(60) NOT NULL DEFAULT '',\n" +
+		"`user_status` int(11) NOT NULL DEFAULT '0',\n" +
+		"`user_email` varchar(100) NOT NULL DEFAULT '',\n" +
+		"`display_name` varchar(250) NOT NULL DEFAULT '',\n" +
+		"PRIMARY KEY (`ID`)\n" +
+		") ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;\n" +
+		"insert into wp_users values (1, 'admin', 0, 'elinor@example.org', 'Cordelia Fritsch'), (2, 'spammer', 1, 'alan.green@example.net', 'Spammer');\n"
+
+	result := processString(t, config, query)
+
+	if result != wants {
+		t.Error("\nExpected:\n", wants, "\nActual:\n", result)
+	}
+}
+
+func TestFieldsResolvedFromCompleteInsert(t *testing.T) {
+	faker.Seed(432)
+
+	config := Config{
+		Patterns: []ConfigPattern{
+			{
+				TableName: "wp_users",
+				Fields: []PatternField{
+					{Field: "user_login", Type: "username"},
+					{Field: "user_email", Type: "email"},
+					{Field: "display_name", Type: "name"},
+				},
+			},
+		},
+	}
+
+	// Columns are listed in a different order than the table definition, so
+	// only matching by name gets the right values
+	query := "INSERT INTO `wp_users` (`user_email`, `ID`, `user_url`, `user_login`, `user_pass`, `user_nicename`, `user_registered`, `user_activation_key`, `user_status`, `display_name`) VALUES ('hosting@humanmade.com',1,'','username','user_pass','username','2019-06-12 00:59:19','',0,'username');\n"
+	wants := "insert into wp_users(user_email, ID, user_url, user_login, user_pass, user_nicename, user_registered, user_activation_key, user_status, display_name) values ('josefa@example.com', 1, '', 'elinor', 'user_pass', 'username', '2019-06-12 00:59:19', '', 0, 'Jacinthe Sawayn');\n"
+
+	result := processString(t, config, query)
+
+	if result != wants {
+		t.Error("\nExpected:\n", wants, "\nActual:\n", result)
+	}
+}
+
+func TestStalePositionWithCompleteInsert(t *testing.T) {
+	query := "INSERT INTO `wp_users` (`ID`, `user_email`, `user_login`) VALUES (1,'hosting@humanmade.com','username');"
+
+	parsed, err := parseLine(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The example config expects user_login to be the 2nd column
+	_, err = applyConfigToParsedLine(parsed, jsonConfig, nil, newPseudonymizer(nil), nil)
+	if err == nil {
+		t.Error("Expected an error for a position that doesn't match the INSERT's columns")
+	}
+}
+
+func TestFieldWithUnknownColumns(t *testing.T) {
+	config := Config{
+		Patterns: []ConfigPattern{
+			{
+				TableName: "wp_users",
+				Fields: []PatternField{
+					{Field: "user_email", Type: "email"},
+				},
+			},
+		},
+	}
+	query := "INSERT INTO `wp_users` VALUES (1,'hosting@humanmade.com');"
+
+	parsed, err := parseLine(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Without a CREATE TABLE statement there's no telling which value is the email
+	_, err = applyConfigToParsedLine(parsed, config, newTableSchemas(), newPseudonymizer(nil), nil)
+	if err == nil {
+		t.Error("Expected an error for a field whose column can't be found")
+	}
+}
+
+func TestPositionOnlyFields(t *testing.T) {
+	config := Config{
+		Patterns: []ConfigPattern{
+			{
+				TableName: "wp_users",
+				Fields: []PatternField{
+					{Position: 3, Type: "email"},
+				},
+			},
+		},
+	}
+
+	tests := []struct {
+		name  string
+		query string
+	}{
+		{
+			name: "create table",
+			query: "CREATE TABLE `wp_users` (\n" +
+				"  `ID` bigint(20) unsigned NOT NULL AUTO_INCREMENT,\n" +
+				"  `user_login` varchar(60) NOT NULL DEFAULT '',\n" +
+				"  `user_email` varchar(100) NOT NULL DEFAULT '',\n" +
+				"  PRIMARY KEY (`ID`)\n" +
+				") ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;\n" +
+				"INSERT INTO `wp_users` VALUES (1,'admin','hosting@humanmade.com');\n",
+		},
+		{
+			name:  "complete insert",
+			query: "INSERT INTO `wp_users` (`ID`, `user_login`, `user_email`) VALUES (1,'admin','hosting@humanmade.com');\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result := processString(t, config, test.query)
+
+			if !strings.Contains(result, "(1, 'admin', '") || strings.Contains(result, "hosting@humanmade.com") {
+				t.Error("Expected the email to be replaced by position, got:\n", result)
+			}
+		})
+	}
+}
+
+func TestDeterministicReplacements(t *testing.T) {
+	config := jsonConfig
+	config.Key = []byte("secret")
+
+	// The same email appears in both rows and has to get the same replacement
+	query := "INSERT INTO `wp_users` VALUES (1,'username','user_pass','username','hosting@humanmade.com','','2019-06-12 00:59:19','',0,'username'),(2,'username','user_pass','username','hosting@humanmade.com','http://notreal.com/username','2019-06-12 00:59:19','',0,'username');\n"
+	wants := "insert into wp_users values (1, 'rodger_aufderhar', 'vKhcpW7p3ZvLy', 'rodger_aufderhar', 'eddie.volkman@example.org', '', '2019-06-12 00:59:19', '', 0, 'Paolo O\\'Kon MD'), (2, 'rodger_aufderhar', 'vKhcpW7p3ZvLy', 'rodger_aufderhar', 'eddie.volkman@example.org', 'http://sanford.name/anastacio.hane', '2019-06-12 00:59:19', '', 0, 'Paolo O\\'Kon MD');\n"
+
+	for run := 0; run < 2; run++ {
+		result := processString(t, config, query)
+
+		if result != wants {
+			t.Error("\nExpected:\n", wants, "\nActual:\n", result)
+		}
+	}
+}
+
+func TestConsistentReplacementsAcrossTables(t *testing.T) {
+	faker.Seed(432)
+
+	config := Config{
+		Patterns: []ConfigPattern{
+			{
+				TableName: "wp_users",
+				Fields: []PatternField{
+					{Field: "user_email", Type: "email", ConsistencyKey: "email"},
+				},
+			},
+			{
+				TableName: "wp_comments",
+				Fields: []PatternField{
+					{Field: "comment_author_email", Type: "email", ConsistencyKey: "email"},
+				},
+			},
+		},
+	}
+	query := "INSERT INTO `wp_users` (`ID`, `user_email`) VALUES (1,'hosting@humanmade.com'),(2,'hosting@humanmade.com');\n" +
+		"INSERT INTO `wp_comments` (`comment_ID`, `comment_author_email`) VALUES (1,'hosting@humanmade.com');\n"
+	wants := "insert into wp_users(ID, user_email) values (1, 'madaline_christiansen@example.net'), (2, 'madaline_christiansen@example.net');\n" +
+		"insert into wp_comments(comment_ID, comment_author_email) values (1, 'madaline_christiansen@example.net');\n"
+
+	result := processString(t, config, query)
+
+	if result != wants {
+		t.Error("\nExpected:\n", wants, "\nActual:\n", result)
+	}
+}
+
+func TestNullsAndExpressions(t *testing.T) {
+	faker.Seed(432)
+
+	config := Config{
+		Patterns: []ConfigPattern{
+			{
+				TableName: "wp_users",
+				Fields: []PatternField{
+					{Field: "user_login", Type: "username"},
+					{Field: "user_email", Type: "email", NullPolicy: "replace"},
+					{Field: "user_url", Type: "url", NullPolicy: "empty"},
+					{Field: "user_registered", Type: "paragraph"},
+					{Field: "display_name", Type: "name", NullPolicy: "keep"},
+				},
+			},
+		},
+	}
+	query := "INSERT INTO `wp_users` (`ID`, `user_login`, `user_email`, `user_url`, `user_registered`, `display_name`) VALUES (1,NULL,NULL,NULL,NOW(),NULL),(2,true,NULL,'',NULL,'Admin');\n"
+	wants := "insert into wp_users(ID, user_login, user_email, user_url, user_registered, display_name) values (1, null, 'elinor@example.org', '', NOW(), null), (2, true, 'cordelia@example.net', '', null, 'Annamarie Ondricka');\n"
+
+	result := processString(t, config, query)
+
+	if result != wants {
+		t.Error("\nExpected:\n", wants, "\nActual:\n", result)
+	}
+}
+
+func TestNegativeNumbers(t *testing.T) {
+	config := Config{
+		Patterns: []ConfigPattern{
+			{
+				TableName: "wp_orders",
+				Fields: []PatternField{
+					{Field: "balance", Type: "round", Options: TransformationOptions{Nearest: 100}},
+					{Field: "refund", Type: "round"},
+				},
+			},
+		},
+	}
+	query := "INSERT INTO `wp_orders` (`ID`, `balance`, `refund`) VALUES (1,-1234.56,-52345);\n"
+	wants := "insert into wp_orders(ID, balance, refund) values (1, -1200.00, -52350);\n"
+
+	result := processString(t, config, query)
+
+	if result != wants {
+		t.Error("\nExpected:\n", wants, "\nActual:\n", result)
+	}
+}
+
+func TestInsertForms(t *testing.T) {
+
+	config := Config{
+		Patterns: []ConfigPattern{
+			{
+				TableName: "wp_users",
+				Fields: []PatternField{
+					{Field: "user_email", Type: "email"},
+					{
+						Field: "display_name",
+						Type:  "name",
+						Constraints: []PatternFieldConstraint{
+							{Field: "user_login", Value: "admin"},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		wants string
+	}{
+		{
+			name:  "replace",
+			query: "REPLACE INTO `wp_users` (`ID`, `user_login`, `user_email`) VALUES (1,'admin','hosting@humanmade.com');\n",
+			wants: "replace into wp_users(ID, user_login, user_email) values (1, 'admin', 'elinor@example.org');\n",
+		},
+		{
+			name:  "insert ignore",
+			query: "INSERT IGNORE INTO `wp_users` (`ID`, `user_login`, `user_email`) VALUES (1,'admin','hosting@humanmade.com');\n",
+			wants: "insert ignore into wp_users(ID, user_login, user_email) values (1, 'admin', 'elinor@example.org');\n",
+		},
+		{
+			name:  "lowercase",
+			query: "insert into `wp_users` (`ID`, `user_login`, `user_email`) values (1,'admin','hosting@humanmade.com');\n",
+			wants: "insert into wp_users(ID, user_login, user_email) values (1, 'admin', 'elinor@example.org');\n",
+		},
+		{
+			name:  "on duplicate key update",
+			query: "INSERT INTO `wp_users` (`ID`, `user_login`, `user_email`) VALUES (1,'admin','hosting@humanmade.com') ON DUPLICATE KEY UPDATE `user_email` = 'hosting@humanmade.com', `display_name` = 'Admin', `user_login` = VALUES(`user_login`);\n",
+			wants: "insert into wp_users(ID, user_login, user_email) values (1, 'admin', 'elinor@example.org') on duplicate key update user_email = 'cordelia@example.net', display_name = 'Annamarie Ondricka', user_login = values(user_login);\n",
+		},
+		{
+			name:  "on duplicate key update without matching constraints",
+			query: "INSERT INTO `wp_users` (`ID`, `user_login`, `user_email`) VALUES (2,'editor','hosting@humanmade.com') ON DUPLICATE KEY UPDATE `display_name` = 'Editor', `user_email` = VALUES(`user_email`);\n",
+			wants: "insert into wp_users(ID, user_login, user_email) values (2, 'editor', 'elinor@example.org') on duplicate key update display_name = 'Editor', user_email = values(user_email);\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			faker.Seed(432)
+
+			result := processString(t, config, test.query)
 
 			if result != test.wants {
 				t.Error("\nExpected:\n", test.wants, "\nActual:\n", result)
@@ -101,3 +442,152 @@ func TestSetupAndProcessInput(t *testing.T) {
 		})
 	}
 }
+
+func TestUnparseableStatements(t *testing.T) {
+	tests := []struct {
+		name      string
+		query     string
+		wantsFail bool
+	}{
+		{
+			name:      "low priority",
+			query:     "INSERT LOW_PRIORITY INTO `wp_users` VALUES (1,'admin','hosting@humanmade.com');",
+			wantsFail: true,
+		},
+		{
+			name:      "delayed ignore",
+			query:     "INSERT DELAYED IGNORE INTO `wp_users` VALUES (1,'admin','hosting@humanmade.com');",
+			wantsFail: true,
+		},
+		{
+			name:      "value",
+			query:     "INSERT INTO `wp_users` VALUE (1,'admin','hosting@humanmade.com');",
+			wantsFail: true,
+		},
+		{
+			name:  "table that isn't configured",
+			query: "INSERT LOW_PRIORITY INTO `wp_links` VALUES (1,'https://humanmade.com');",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, err := processLine(test.query, jsonConfig, nil, newPseudonymizer(nil), nil)
+
+			if test.wantsFail && err == nil {
+				t.Error("Expected an error, got:\n", result)
+			}
+			if !test.wantsFail && (err != nil || result != test.query) {
+				t.Error("Expected the statement to be passed through, got:\n", result, err)
+			}
+		})
+	}
+}
+
+func TestParallelRows(t *testing.T) {
+	config := Config{
+		Workers: 4,
+		Patterns: []ConfigPattern{
+			{
+				TableName: "wp_users",
+				Fields: []PatternField{
+					{Field: "user_email", Position: 2, Type: "email", ConsistencyKey: "email"},
+					{Field: "display_name", Position: 3, Type: "name"},
+				},
+			},
+		},
+	}
+
+	// Enough rows for several chunks, all with the same email
+	var query strings.Builder
+	query.WriteString("INSERT INTO `wp_users` VALUES ")
+	for i := 0; i < 3*rowChunkSize; i++ {
+		if i > 0 {
+			query.WriteString(",")
+		}
+		fmt.Fprintf(&query, "(%d,'hosting@humanmade.com','User %d')", i, i)
+	}
+	query.WriteString(";\n")
+
+	var results []string
+	for run := 0; run < 3; run++ {
+		faker.Seed(432)
+		result, err := processLine(query.String(), config, nil, newPseudonymizer(nil), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		results = append(results, result)
+	}
+
+	for _, result := range results[1:] {
+		if result != results[0] {
+			t.Error("Expected the same seed to give the same replacements")
+		}
+	}
+
+	parsed, err := parseLine(results[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := parsed.(*sqlparser.Insert).Rows.(sqlparser.Values)
+	email := sqlparser.String(rows[0][1])
+	names := make(map[string]bool)
+	for _, row := range rows {
+		if replacement := sqlparser.String(row[1]); replacement != email {
+			t.Errorf("Expected every row to have the email %s, got %s", email, replacement)
+		}
+		names[sqlparser.String(row[2])] = true
+	}
+	if len(names) < len(rows)/2 {
+		t.Errorf("Expected the rows to get different names, got %d names for %d rows", len(names), len(rows))
+	}
+}
+
+func TestSameOutputWithAnyNumberOfWorkers(t *testing.T) {
+	patterns := []ConfigPattern{
+		{
+			TableName: "wp_users",
+			Fields: []PatternField{
+				{Field: "user_email", Position: 2, Type: "email", ConsistencyKey: "email"},
+				{Field: "display_name", Position: 3, Type: "name"},
+			},
+		},
+		{
+			TableName: "wp_orders",
+			Fields: []PatternField{
+				{Field: "total", Position: 2, Type: "noise"},
+				{Field: "created", Position: 3, Type: "date"},
+			},
+		},
+	}
+
+	// Several statements, some of them with enough rows for several chunks
+	var query strings.Builder
+	for statement := 0; statement < 4; statement++ {
+		query.WriteString("INSERT INTO `wp_users` VALUES ")
+		for i := 0; i < (statement+1)*rowChunkSize; i++ {
+			if i > 0 {
+				query.WriteString(",")
+			}
+			fmt.Fprintf(&query, "(%d,'user%d@humanmade.com','User %d')", i, i%50, i)
+		}
+		query.WriteString(";\nINSERT INTO `wp_orders` VALUES ")
+		for i := 0; i < rowChunkSize+10; i++ {
+			if i > 0 {
+				query.WriteString(",")
+			}
+			fmt.Fprintf(&query, "(%d,%d.99,'2019-06-12 00:59:19')", i, i)
+		}
+		query.WriteString(";\n")
+	}
+
+	var results []string
+	for _, workers := range []int{1, 4} {
+		faker.Seed(432)
+		results = append(results, processString(t, Config{Workers: workers, Patterns: patterns}, query.String()))
+	}
+
+	if results[0] != results[1] {
+		t.Error("Expected the same output with 1 and 4 workers")
+	}
+}
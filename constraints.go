@@ -0,0 +1,279 @@
+package main
+
+import (
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"github.com/xwb1989/sqlparser"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Operators supported by PatternFieldConstraint. An empty operator is the same
+// as "equals" so that configs written before operators existed keep working.
+const (
+	operatorEquals       = "equals"
+	operatorRegex        = "regex"
+	operatorLike         = "like"
+	operatorPrefix       = "prefix"
+	operatorSuffix       = "suffix"
+	operatorIn           = "in"
+	operatorGreater      = ">"
+	operatorGreaterEqual = ">="
+	operatorLess         = "<"
+	operatorLessEqual    = "<="
+	operatorBetween      = "between"
+	operatorIsNull       = "isNull"
+	operatorIsEmpty      = "isEmpty"
+)
+
+var (
+	// Regular expressions are compiled once and shared between every row and
+	// goroutine making use of them
+	constraintPatterns sync.Map
+)
+
+// rowMatchesAll reports whether the row obeys every one of the constraints.
+func rowMatchesAll(constraints []PatternFieldConstraint, row sqlparser.ValTuple, columns map[string]int) bool {
+	for _, constraint := range constraints {
+		if !constraint.matchesRow(row, columns) {
+			return false
+		}
+	}
+	return true
+}
+
+// rowMatchesAny reports whether the row obeys at least one of the constraints.
+func rowMatchesAny(constraints []PatternFieldConstraint, row sqlparser.ValTuple, columns map[string]int) bool {
+	for _, constraint := range constraints {
+		if constraint.matchesRow(row, columns) {
+			return true
+		}
+	}
+	return false
+}
+
+// isGroup reports whether the constraint groups other constraints rather
+// than comparing a field.
+func (c PatternFieldConstraint) isGroup() bool {
+	return c.All != nil || c.Any != nil || c.None != nil
+}
+
+// matchesRow reports whether the row obeys the constraint, evaluating nested
+// groups as needed. A group setting more than one of all, any and none only
+// matches when each of them does.
+func (c PatternFieldConstraint) matchesRow(row sqlparser.ValTuple, columns map[string]int) bool {
+	if c.isGroup() {
+		matched := true
+		if c.All != nil {
+			matched = matched && rowMatchesAll(c.All, row, columns)
+		}
+		if c.Any != nil {
+			matched = matched && rowMatchesAny(c.Any, row, columns)
+		}
+		if c.None != nil {
+			matched = matched && !rowMatchesAny(c.None, row, columns)
+		}
+		return matched != c.Not
+	}
+
+	valTupleIndex, err := resolveColumnIndex(c.Field, c.Position, columns)
+	if err != nil || valTupleIndex >= len(row) {
+		// A constraint we can't check must not match, otherwise we'd modify
+		// values we weren't asked to
+		return false
+	}
+	return c.matches(row[valTupleIndex])
+}
+
+// walk calls fn for the constraint and every constraint nested inside it,
+// stopping at the first error.
+func (c PatternFieldConstraint) walk(fn func(PatternFieldConstraint) error) error {
+	if err := fn(c); err != nil {
+		return err
+	}
+	for _, group := range [][]PatternFieldConstraint{c.All, c.Any, c.None} {
+		for _, nested := range group {
+			if err := nested.walk(fn); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// validate checks the constraint and any nested constraints can be
+// evaluated, so that mistakes in the config are reported before any data is
+// processed.
+func (c PatternFieldConstraint) validate() error {
+	return c.walk(func(constraint PatternFieldConstraint) error {
+		return constraint.validateComparison()
+	})
+}
+
+func (c PatternFieldConstraint) validateComparison() error {
+	if c.isGroup() {
+		if c.Field != "" || c.Operator != "" {
+			return fmt.Errorf("constraint group can't also compare field %s", c.Field)
+		}
+		return nil
+	}
+
+	switch c.Operator {
+	case "", operatorEquals, operatorPrefix, operatorSuffix, operatorIn, operatorIsNull, operatorIsEmpty:
+		return nil
+	case operatorRegex, operatorLike:
+		_, err := c.pattern()
+		return err
+	case operatorGreater, operatorGreaterEqual, operatorLess, operatorLessEqual:
+		_, err := strconv.ParseFloat(c.Value, 64)
+		return err
+	case operatorBetween:
+		if len(c.Values) != 2 {
+			return fmt.Errorf("between constraint on %s needs exactly 2 values", c.Field)
+		}
+		for _, value := range c.Values {
+			if _, err := strconv.ParseFloat(value, 64); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown constraint operator %q on %s", c.Operator, c.Field)
+}
+
+// matches reports whether the value obeys the constraint.
+func (c PatternFieldConstraint) matches(expr sqlparser.Expr) bool {
+	parsedValue, isNull := exprToString(expr)
+
+	logrus.WithFields(logrus.Fields{
+		"parsedValue":         parsedValue,
+		"constraint.operator": c.Operator,
+		"constraint.value":    c.Value,
+		"constraint.values":   c.Values,
+	}).Trace("Debuging constraint obediance: ")
+
+	return c.compare(parsedValue, isNull) != c.Not
+}
+
+func (c PatternFieldConstraint) compare(parsedValue string, isNull bool) bool {
+	switch c.Operator {
+	case operatorIsNull:
+		return isNull
+	case operatorIsEmpty:
+		return isNull || parsedValue == ""
+	}
+
+	// NULL never equals, contains or compares to anything
+	if isNull {
+		return false
+	}
+
+	switch c.Operator {
+	case "", operatorEquals:
+		return parsedValue == c.Value
+	case operatorPrefix:
+		return strings.HasPrefix(parsedValue, c.Value)
+	case operatorSuffix:
+		return strings.HasSuffix(parsedValue, c.Value)
+	case operatorIn:
+		for _, value := range c.Values {
+			if parsedValue == value {
+				return true
+			}
+		}
+		return false
+	case operatorRegex, operatorLike:
+		pattern, err := c.pattern()
+		if err != nil {
+			return false
+		}
+		return pattern.MatchString(parsedValue)
+	case operatorGreater, operatorGreaterEqual, operatorLess, operatorLessEqual, operatorBetween:
+		return c.compareNumbers(parsedValue)
+	}
+	return false
+}
+
+func (c PatternFieldConstraint) compareNumbers(parsedValue string) bool {
+	number, err := strconv.ParseFloat(parsedValue, 64)
+	if err != nil {
+		return false
+	}
+
+	if c.Operator == operatorBetween {
+		if len(c.Values) != 2 {
+			return false
+		}
+		low, lowErr := strconv.ParseFloat(c.Values[0], 64)
+		high, highErr := strconv.ParseFloat(c.Values[1], 64)
+		return lowErr == nil && highErr == nil && number >= low && number <= high
+	}
+
+	target, err := strconv.ParseFloat(c.Value, 64)
+	if err != nil {
+		return false
+	}
+
+	switch c.Operator {
+	case operatorGreater:
+		return number > target
+	case operatorGreaterEqual:
+		return number >= target
+	case operatorLess:
+		return number < target
+	default:
+		return number <= target
+	}
+}
+
+// pattern returns the compiled regular expression for regex and like
+// constraints.
+func (c PatternFieldConstraint) pattern() (*regexp.Regexp, error) {
+	expression := c.Value
+	if c.Operator == operatorLike {
+		expression = likeToRegex(c.Value)
+	}
+
+	if pattern, ok := constraintPatterns.Load(expression); ok {
+		return pattern.(*regexp.Regexp), nil
+	}
+
+	pattern, err := regexp.Compile(expression)
+	if err != nil {
+		return nil, err
+	}
+	constraintPatterns.Store(expression, pattern)
+	return pattern, nil
+}
+
+// likeToRegex converts an SQL LIKE pattern into an equivalent regular
+// expression. Like MySQL's default collations, the match is case-insensitive.
+func likeToRegex(like string) string {
+	var expression strings.Builder
+	expression.WriteString("(?is)^")
+
+	escaped := false
+	for _, char := range like {
+		switch {
+		case escaped:
+			expression.WriteString(regexp.QuoteMeta(string(char)))
+			escaped = false
+		case char == '\\':
+			escaped = true
+		case char == '%':
+			expression.WriteString(".*")
+		case char == '_':
+			expression.WriteString(".")
+		default:
+			expression.WriteString(regexp.QuoteMeta(string(char)))
+		}
+	}
+	if escaped {
+		expression.WriteString(regexp.QuoteMeta("\\"))
+	}
+
+	expression.WriteString("$")
+	return expression.String()
+}
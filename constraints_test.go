@@ -0,0 +1,218 @@
+package main
+
+import (
+	"github.com/xwb1989/sqlparser"
+	"testing"
+)
+
+func TestConstraintMatches(t *testing.T) {
+
+	var tests = []struct {
+		testName   string
+		constraint PatternFieldConstraint
+		value      sqlparser.Expr
+		wants      bool
+	}{
+		{
+			testName:   "equals",
+			constraint: PatternFieldConstraint{Value: "first_name"},
+			value:      sqlparser.NewStrVal([]byte("first_name")),
+			wants:      true,
+		},
+		{
+			testName:   "not equals",
+			constraint: PatternFieldConstraint{Value: "first_name", Not: true},
+			value:      sqlparser.NewStrVal([]byte("first_name")),
+			wants:      false,
+		},
+		{
+			testName:   "regex",
+			constraint: PatternFieldConstraint{Operator: "regex", Value: "^_(billing|shipping)_"},
+			value:      sqlparser.NewStrVal([]byte("_shipping_phone")),
+			wants:      true,
+		},
+		{
+			testName:   "prefix",
+			constraint: PatternFieldConstraint{Operator: "prefix", Value: "_billing_"},
+			value:      sqlparser.NewStrVal([]byte("_billing_email")),
+			wants:      true,
+		},
+		{
+			testName:   "suffix",
+			constraint: PatternFieldConstraint{Operator: "suffix", Value: "_email"},
+			value:      sqlparser.NewStrVal([]byte("_billing_phone")),
+			wants:      false,
+		},
+		{
+			testName:   "in",
+			constraint: PatternFieldConstraint{Operator: "in", Values: []string{"first_name", "last_name"}},
+			value:      sqlparser.NewStrVal([]byte("last_name")),
+			wants:      true,
+		},
+		{
+			testName:   "greater than",
+			constraint: PatternFieldConstraint{Operator: ">", Value: "10"},
+			value:      sqlparser.NewIntVal([]byte("9")),
+			wants:      false,
+		},
+		{
+			testName:   "less than or equal",
+			constraint: PatternFieldConstraint{Operator: "<=", Value: "10"},
+			value:      sqlparser.NewFloatVal([]byte("10.0")),
+			wants:      true,
+		},
+		{
+			testName:   "between",
+			constraint: PatternFieldConstraint{Operator: "between", Values: []string{"18", "65"}},
+			value:      sqlparser.NewIntVal([]byte("42")),
+			wants:      true,
+		},
+		{
+			testName:   "numeric comparison of text",
+			constraint: PatternFieldConstraint{Operator: ">", Value: "10"},
+			value:      sqlparser.NewStrVal([]byte("abc")),
+			wants:      false,
+		},
+		{
+			testName:   "is null",
+			constraint: PatternFieldConstraint{Operator: "isNull"},
+			value:      &sqlparser.NullVal{},
+			wants:      true,
+		},
+		{
+			testName:   "null doesn't equal anything",
+			constraint: PatternFieldConstraint{Value: ""},
+			value:      &sqlparser.NullVal{},
+			wants:      false,
+		},
+		{
+			testName:   "is empty",
+			constraint: PatternFieldConstraint{Operator: "isEmpty"},
+			value:      sqlparser.NewStrVal([]byte("")),
+			wants:      true,
+		},
+		{
+			testName:   "like",
+			constraint: PatternFieldConstraint{Operator: "like", Value: "\\_transient\\_%"},
+			value:      sqlparser.NewStrVal([]byte("_TRANSIENT_feed_123")),
+			wants:      true,
+		},
+		{
+			testName:   "like with single character wildcard",
+			constraint: PatternFieldConstraint{Operator: "like", Value: "user_"},
+			value:      sqlparser.NewStrVal([]byte("users")),
+			wants:      true,
+		},
+		{
+			testName:   "like matches the whole value",
+			constraint: PatternFieldConstraint{Operator: "like", Value: "user_"},
+			value:      sqlparser.NewStrVal([]byte("user_email")),
+			wants:      false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.testName, func(t *testing.T) {
+			if err := test.constraint.validate(); err != nil {
+				t.Fatal(err)
+			}
+
+			result := test.constraint.matches(test.value)
+			if result != test.wants {
+				t.Error("\nExpected:\n", test.wants, "\nActual:\n", result)
+			}
+		})
+	}
+}
+
+func TestConstraintValidate(t *testing.T) {
+	for _, constraint := range []PatternFieldConstraint{
+		{Operator: "contains", Value: "foo"},
+		{Operator: "regex", Value: "("},
+		{Operator: ">", Value: "ten"},
+		{Operator: "between", Values: []string{"1"}},
+	} {
+		if err := constraint.validate(); err == nil {
+			t.Errorf("Expected an error validating %+v", constraint)
+		}
+	}
+}
+
+func TestConstraintGroups(t *testing.T) {
+	columns := map[string]int{"meta_id": 0, "user_id": 1, "meta_key": 2, "meta_value": 3}
+	row := sqlparser.ValTuple{
+		sqlparser.NewIntVal([]byte("1")),
+		sqlparser.NewIntVal([]byte("2")),
+		sqlparser.NewStrVal([]byte("nickname")),
+		sqlparser.NewStrVal([]byte("Jim")),
+	}
+
+	var tests = []struct {
+		testName    string
+		constraints []PatternFieldConstraint
+		wants       bool
+	}{
+		{
+			testName: "any",
+			constraints: []PatternFieldConstraint{
+				{Any: []PatternFieldConstraint{
+					{Field: "meta_key", Value: "first_name"},
+					{Field: "meta_key", Value: "nickname"},
+				}},
+			},
+			wants: true,
+		},
+		{
+			testName: "all",
+			constraints: []PatternFieldConstraint{
+				{All: []PatternFieldConstraint{
+					{Field: "meta_key", Value: "nickname"},
+					{Field: "user_id", Value: "1"},
+				}},
+			},
+			wants: false,
+		},
+		{
+			testName: "none",
+			constraints: []PatternFieldConstraint{
+				{None: []PatternFieldConstraint{
+					{Field: "meta_key", Value: "first_name"},
+					{Field: "meta_key", Value: "last_name"},
+				}},
+			},
+			wants: true,
+		},
+		{
+			testName: "nested",
+			constraints: []PatternFieldConstraint{
+				{Field: "user_id", Value: "2"},
+				{Any: []PatternFieldConstraint{
+					{Field: "meta_key", Value: "first_name"},
+					{All: []PatternFieldConstraint{
+						{Field: "meta_key", Operator: "suffix", Value: "name"},
+						{Field: "meta_value", Operator: "isEmpty", Not: true},
+					}},
+				}},
+			},
+			wants: true,
+		},
+		{
+			testName: "negated group",
+			constraints: []PatternFieldConstraint{
+				{Not: true, Any: []PatternFieldConstraint{
+					{Field: "meta_key", Value: "nickname"},
+				}},
+			},
+			wants: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.testName, func(t *testing.T) {
+			result := rowMatchesAll(test.constraints, row, columns)
+			if result != test.wants {
+				t.Error("\nExpected:\n", test.wants, "\nActual:\n", result)
+			}
+		})
+	}
+}
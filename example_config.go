@@ -52,9 +52,18 @@ var ExampleWordPressConfig = Config{
 					Type:     "firstName",
 					Constraints: []PatternFieldConstraint{
 						{
-							Field:    "meta_key",
-							Position: 3,
-							Value:    "first_name",
+							Any: []PatternFieldConstraint{
+								{
+									Field:    "meta_key",
+									Position: 3,
+									Value:    "first_name",
+								},
+								{
+									Field:    "meta_key",
+									Position: 3,
+									Value:    "nickname",
+								},
+							},
 						},
 					},
 				},
@@ -70,18 +79,6 @@ var ExampleWordPressConfig = Config{
 						},
 					},
 				},
-				{
-					Field:    "meta_value",
-					Position: 4,
-					Type:     "firstName",
-					Constraints: []PatternFieldConstraint{
-						{
-							Field:    "meta_key",
-							Position: 3,
-							Value:    "nickname",
-						},
-					},
-				},
 				{
 					Field:    "meta_value",
 					Position: 4,
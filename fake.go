@@ -0,0 +1,229 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"regexp"
+	"strings"
+	"syreclabs.com/go/faker/locales"
+)
+
+// fakeGenerator generates the same kinds of fake values as faker, from the
+// same locale data, but with a random source of its own. faker only has a
+// single, global random source, so using it from several workers at once
+// means taking turns, and the values each worker gets depend on the order the
+// turns happen to come in.
+//
+// The values are drawn from the random source exactly the way faker draws
+// them, so a generator seeded with a given seed generates the same values as
+// faker seeded with it, other than where faker would fail to generate a value
+// in the locale at all.
+type fakeGenerator struct {
+	rand   *rand.Rand
+	locale map[string]interface{}
+}
+
+func newFakeGenerator(seed int64) *fakeGenerator {
+	return &fakeGenerator{
+		rand:   rand.New(rand.NewSource(seed)),
+		locale: locales.En,
+	}
+}
+
+// seed resets the random source, so that the values generated next only
+// depend on the seed.
+func (f *fakeGenerator) seed(seed int64) {
+	f.rand.Seed(seed)
+}
+
+const (
+	fakeDigits  = "0123456789"
+	fakeLetters = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+)
+
+var (
+	fakeSeparators = []string{".", "_"}
+	fakeDomains    = []string{"com", "org", "net"}
+	fakeReference  = regexp.MustCompile(`#\{([A-Za-z]+\.[^\}]+)\}`)
+	fakeNonWord    = regexp.MustCompile(`\W`)
+)
+
+// randomInt returns a random number in the [min, max] range.
+func (f *fakeGenerator) randomInt(min, max int) int {
+	if max <= min {
+		return min
+	}
+	return min + f.rand.Intn(max-min+1)
+}
+
+// randomInt64 returns a random number in the [min, max] range.
+func (f *fakeGenerator) randomInt64(min, max int64) int64 {
+	if max <= min {
+		return min
+	}
+	return min + f.rand.Int63n(max-min+1)
+}
+
+// randomFraction returns a random number in [0, 1).
+func (f *fakeGenerator) randomFraction() float64 {
+	return float64(f.randomInt64(0, 1<<53-1)) / (1 << 53)
+}
+
+func (f *fakeGenerator) randomChoice(choices []string) string {
+	return choices[f.rand.Intn(len(choices))]
+}
+
+// fetch returns a random value from the locale data at the path, such as
+// "name.first_name", filling in the references to other paths it contains.
+func (f *fakeGenerator) fetch(path string) string {
+	var result string
+	switch value := f.valueAt(path).(type) {
+	case [][]string:
+		choices := make([]string, len(value))
+		for i, choice := range value {
+			choices[i] = f.randomChoice(choice)
+		}
+		result = strings.Join(choices, " ")
+	case []string:
+		result = f.randomChoice(value)
+	case string:
+		result = value
+	default:
+		panic(fmt.Sprintf("%v: invalid value type %T", path, value))
+	}
+
+	for _, reference := range fakeReference.FindAllStringSubmatch(result, -1) {
+		result = strings.Replace(result, reference[0], f.fetch(strings.ToLower(reference[1])), 1)
+	}
+	return result
+}
+
+// valueAt looks the path up in the locale, falling back to English when the
+// locale doesn't have it, or only has an empty list there, such as the Italian
+// name suffixes.
+func (f *fakeGenerator) valueAt(path string) interface{} {
+	for _, locale := range []map[string]interface{}{f.locale, locales.En} {
+		var value interface{} = locale
+		for _, key := range strings.Split(path, ".") {
+			if value = value.(map[string]interface{})[key]; value == nil {
+				break
+			}
+		}
+		if !isEmptyChoice(value) {
+			return value
+		}
+	}
+	panic(fmt.Sprintf("%v: invalid path", path))
+}
+
+// isEmptyChoice reports whether there's nothing to choose from in the value.
+func isEmptyChoice(value interface{}) bool {
+	switch value := value.(type) {
+	case nil:
+		return true
+	case []string:
+		return len(value) == 0
+	case [][]string:
+		for _, choice := range value {
+			if len(choice) == 0 {
+				return true
+			}
+		}
+		return len(value) == 0
+	}
+	return false
+}
+
+func (f *fakeGenerator) name() string {
+	return f.fetch("name.name")
+}
+
+func (f *fakeGenerator) firstName() string {
+	return f.fetch("name.first_name")
+}
+
+func (f *fakeGenerator) lastName() string {
+	return f.fetch("name.last_name")
+}
+
+func (f *fakeGenerator) userName() string {
+	separator := f.randomChoice(fakeSeparators)
+	choices := []string{
+		f.userNamePart(f.firstName),
+		f.userNamePart(f.firstName) + separator + f.userNamePart(f.lastName),
+	}
+	return strings.ToLower(f.randomChoice(choices))
+}
+
+// userNamePart strips a name down to the characters usernames are made of.
+// Names written without Latin letters, such as Japanese ones, leave nothing
+// behind, so an English name is used for them instead.
+func (f *fakeGenerator) userNamePart(name func() string) string {
+	part := fakeNonWord.ReplaceAllString(name(), "")
+	if part == "" {
+		locale := f.locale
+		f.locale = locales.En
+		part = fakeNonWord.ReplaceAllString(name(), "")
+		f.locale = locale
+	}
+	return part
+}
+
+func (f *fakeGenerator) safeEmail() string {
+	return f.userName() + "@example." + f.randomChoice(fakeDomains)
+}
+
+func (f *fakeGenerator) domainName() string {
+	word := strings.Split(f.fetch("company.name"), " ")[0]
+	return strings.ToLower(fakeNonWord.ReplaceAllString(word, "")) + "." + f.fetch("internet.domain_suffix")
+}
+
+func (f *fakeGenerator) url() string {
+	return fmt.Sprintf("http://%s/%s", f.domainName(), f.userName())
+}
+
+func (f *fakeGenerator) password(min, max int) string {
+	characters := make([]byte, f.randomInt(min, max))
+	f.rand.Read(characters)
+	alphabet := fakeDigits + fakeLetters
+	for i, b := range characters {
+		characters[i] = alphabet[b%byte(len(alphabet))]
+	}
+	return string(characters)
+}
+
+func (f *fakeGenerator) ipv4() string {
+	parts := make([]string, 4)
+	for i := range parts {
+		parts[i] = fmt.Sprint(f.rand.Int31n(256))
+	}
+	return strings.Join(parts, ".")
+}
+
+// sentence returns a capitalised sentence of as many lorem ipsum words.
+func (f *fakeGenerator) sentence(words int) string {
+	dictionary := f.valueAt("lorem.words").([]string)
+	order := f.rand.Perm(len(dictionary) * (words/len(dictionary) + 1))
+	chosen := make([]string, words)
+	for i := range chosen {
+		chosen[i] = dictionary[order[i]%len(dictionary)]
+	}
+	sentence := strings.Join(chosen, " ")
+	return strings.ToTitle(sentence[:1]) + sentence[1:] + "."
+}
+
+// numerifyAndLetterify replaces every # with a random digit, the first of
+// which isn't a zero, and then every ? with a random capital letter.
+func (f *fakeGenerator) numerifyAndLetterify(format string) string {
+	for first := true; strings.Contains(format, "#"); first = false {
+		digits := fakeDigits
+		if first {
+			digits = fakeDigits[1:]
+		}
+		format = strings.Replace(format, "#", string(digits[f.rand.Intn(len(digits))]), 1)
+	}
+	for strings.Contains(format, "?") {
+		format = strings.Replace(format, "?", string(fakeLetters[f.rand.Intn(26)]), 1)
+	}
+	return format
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"github.com/xwb1989/sqlparser"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// jsonType is the field type for JSON documents, whose paths are transformed
+// individually.
+const jsonType = "json"
+
+// jsonPathSegment is one step of a JSON path such as $.addresses[*].phone.
+type jsonPathSegment struct {
+	key      string
+	index    int
+	isIndex  bool
+	wildcard bool
+}
+
+// parseJSONPath parses the subset of JSONPath we support: a leading $
+// followed by .key, ['key'], [N], .* and [*] steps.
+func parseJSONPath(path string) ([]jsonPathSegment, error) {
+	if !strings.HasPrefix(path, "$") {
+		return nil, fmt.Errorf("JSON path %s has to start with $", path)
+	}
+
+	var segments []jsonPathSegment
+	rest := path[1:]
+	for rest != "" {
+		switch {
+		case strings.HasPrefix(rest, ".*"):
+			segments = append(segments, jsonPathSegment{wildcard: true})
+			rest = rest[2:]
+
+		case rest[0] == '.':
+			end := strings.IndexAny(rest[1:], ".[")
+			if end == -1 {
+				end = len(rest) - 1
+			}
+			if end == 0 {
+				return nil, fmt.Errorf("empty key in JSON path %s", path)
+			}
+			segments = append(segments, jsonPathSegment{key: rest[1 : end+1]})
+			rest = rest[end+1:]
+
+		case rest[0] == '[':
+			end := strings.Index(rest, "]")
+			if end == -1 {
+				return nil, fmt.Errorf("missing ] in JSON path %s", path)
+			}
+			inside := rest[1:end]
+			rest = rest[end+1:]
+
+			if inside == "*" {
+				segments = append(segments, jsonPathSegment{wildcard: true})
+				continue
+			}
+			if len(inside) >= 2 && (inside[0] == '\'' || inside[0] == '"') && inside[len(inside)-1] == inside[0] {
+				segments = append(segments, jsonPathSegment{key: inside[1 : len(inside)-1]})
+				continue
+			}
+			index, err := strconv.Atoi(inside)
+			if err != nil || index < 0 {
+				return nil, fmt.Errorf("invalid index %q in JSON path %s", inside, path)
+			}
+			segments = append(segments, jsonPathSegment{index: index, isIndex: true})
+
+		default:
+			return nil, fmt.Errorf("unexpected %q in JSON path %s", rest[0], path)
+		}
+	}
+	return segments, nil
+}
+
+// jsonNode is a value in a JSON document, keeping track of where it sits in
+// the original text so replacements can be spliced in without touching the
+// rest of the document.
+type jsonNode struct {
+	start, end int
+	// kind is the first character of the value: {, [, ", a digit or -, or the
+	// first letter of true, false and null.
+	kind     byte
+	keys     []string
+	children []*jsonNode
+}
+
+type jsonScanner struct {
+	data string
+	pos  int
+}
+
+// scanJSON builds the tree of nodes of a valid JSON document.
+func scanJSON(data string) (*jsonNode, error) {
+	if !json.Valid([]byte(data)) {
+		return nil, fmt.Errorf("invalid JSON document")
+	}
+	scanner := &jsonScanner{data: data}
+	return scanner.scanValue()
+}
+
+func (s *jsonScanner) skipWhitespace() {
+	for s.pos < len(s.data) && strings.IndexByte(" \t\r\n", s.data[s.pos]) != -1 {
+		s.pos++
+	}
+}
+
+// The document has already been validated, so scanning only needs to find
+// where each value starts and ends.
+func (s *jsonScanner) scanValue() (*jsonNode, error) {
+	s.skipWhitespace()
+	node := &jsonNode{start: s.pos, kind: s.data[s.pos]}
+
+	switch node.kind {
+	case '{', '[':
+		s.pos++
+		for {
+			s.skipWhitespace()
+			if s.data[s.pos] == '}' || s.data[s.pos] == ']' {
+				s.pos++
+				break
+			}
+			if s.data[s.pos] == ',' {
+				s.pos++
+				s.skipWhitespace()
+			}
+
+			if node.kind == '{' {
+				keyStart := s.pos
+				s.scanString()
+				var key string
+				if err := json.Unmarshal([]byte(s.data[keyStart:s.pos]), &key); err != nil {
+					return nil, err
+				}
+				node.keys = append(node.keys, key)
+				s.skipWhitespace()
+				// Skip the colon
+				s.pos++
+			}
+
+			child, err := s.scanValue()
+			if err != nil {
+				return nil, err
+			}
+			node.children = append(node.children, child)
+		}
+
+	case '"':
+		s.scanString()
+
+	default:
+		for s.pos < len(s.data) && strings.IndexByte(",]} \t\r\n", s.data[s.pos]) == -1 {
+			s.pos++
+		}
+	}
+
+	node.end = s.pos
+	return node, nil
+}
+
+func (s *jsonScanner) scanString() {
+	s.pos++
+	for s.data[s.pos] != '"' {
+		if s.data[s.pos] == '\\' {
+			s.pos++
+		}
+		s.pos++
+	}
+	s.pos++
+}
+
+// jsonEdit replaces the text between start and end of the document.
+type jsonEdit struct {
+	start, end  int
+	replacement string
+}
+
+// transformJSON applies the field's path transformations to the values inside
+// a JSON document. Only the replaced values change; key order, whitespace and
+// everything else stays exactly as it was.
+func (p *Pseudonymizer) transformJSON(fieldPattern PatternField, value *sqlparser.SQLVal) *sqlparser.SQLVal {
+	document := literalString(value)
+	root, err := scanJSON(document)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err,
+			"field": fieldPattern.Field,
+		}).Warn("Skipping transformation of value that isn't JSON")
+		return value
+	}
+
+	var edits []jsonEdit
+	for _, pathField := range fieldPattern.Paths {
+		segments, err := parseJSONPath(pathField.Path)
+		if err != nil {
+			// Paths are validated when the config is read, so this shouldn't happen
+			logrus.WithFields(logrus.Fields{
+				"error": err,
+				"field": fieldPattern.Field,
+			}).Error("Failed parsing JSON path")
+			continue
+		}
+
+		for _, node := range root.find(segments) {
+			replacement, ok := p.transformJSONScalar(document[node.start:node.end], node.kind, pathField.patternField(fieldPattern))
+			if ok {
+				edits = append(edits, jsonEdit{start: node.start, end: node.end, replacement: replacement})
+			}
+		}
+	}
+
+	return newValOfType(value, applyJSONEdits(document, edits))
+}
+
+// find returns the nodes the path leads to.
+func (n *jsonNode) find(segments []jsonPathSegment) []*jsonNode {
+	if len(segments) == 0 {
+		return []*jsonNode{n}
+	}
+
+	segment := segments[0]
+	var found []*jsonNode
+	for i, child := range n.children {
+		matched := segment.wildcard
+		if n.kind == '{' && !segment.isIndex && n.keys[i] == segment.key {
+			matched = true
+		}
+		if n.kind == '[' && segment.isIndex && segment.index == i {
+			matched = true
+		}
+		if matched {
+			found = append(found, child.find(segments[1:])...)
+		}
+	}
+	return found
+}
+
+// transformJSONScalar returns the JSON text replacing a string or number.
+func (p *Pseudonymizer) transformJSONScalar(raw string, kind byte, fieldPattern PatternField) (string, bool) {
+	var original *sqlparser.SQLVal
+	switch {
+	case kind == '"':
+		var decoded string
+		if err := json.Unmarshal([]byte(raw), &decoded); err != nil || decoded == "" {
+			return "", false
+		}
+		original = sqlparser.NewStrVal([]byte(decoded))
+	case kind == '-' || (kind >= '0' && kind <= '9'):
+		if strings.ContainsAny(raw, ".eE") {
+			original = sqlparser.NewFloatVal([]byte(raw))
+		} else {
+			original = sqlparser.NewIntVal([]byte(raw))
+		}
+	default:
+		// Nulls, booleans, arrays and objects are left as they are
+		return "", false
+	}
+
+	replacement := p.transform(fieldPattern, original)
+
+	// Numbers stay numbers as long as the transformation kept them that way
+	if original.Type != sqlparser.StrVal && (replacement.Type == sqlparser.IntVal || replacement.Type == sqlparser.FloatVal) {
+		return string(replacement.Val), true
+	}
+
+	var encoded bytes.Buffer
+	encoder := json.NewEncoder(&encoded)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(literalString(replacement)); err != nil {
+		return "", false
+	}
+	return strings.TrimSuffix(encoded.String(), "\n"), true
+}
+
+func applyJSONEdits(document string, edits []jsonEdit) string {
+	// The same value can be matched by more than one path, in which case the
+	// last path wins
+	sort.SliceStable(edits, func(i, j int) bool {
+		return edits[i].start < edits[j].start
+	})
+
+	var result strings.Builder
+	pos := 0
+	for i, edit := range edits {
+		if i+1 < len(edits) && edits[i+1].start == edit.start {
+			continue
+		}
+		result.WriteString(document[pos:edit.start])
+		result.WriteString(edit.replacement)
+		pos = edit.end
+	}
+	result.WriteString(document[pos:])
+	return result.String()
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"github.com/xwb1989/sqlparser"
+	"syreclabs.com/go/faker"
+	"testing"
+)
+
+func TestParseJSONPath(t *testing.T) {
+	for _, path := range []string{"$", "$.customer.email", "$.addresses[*].phone", "$['first name']", "$.items[0].*"} {
+		if _, err := parseJSONPath(path); err != nil {
+			t.Errorf("Failed parsing %s: %s", path, err)
+		}
+	}
+
+	for _, path := range []string{"customer.email", "$..email", "$.items[one]", "$.items[0"} {
+		if _, err := parseJSONPath(path); err == nil {
+			t.Errorf("Expected an error parsing %s", path)
+		}
+	}
+}
+
+func TestTransformJSON(t *testing.T) {
+	faker.Seed(432)
+
+	fieldPattern := PatternField{
+		Field: "payload",
+		Type:  "json",
+		Paths: []PathField{
+			{Path: "$.customer.email", Type: "email"},
+			{Path: "$.addresses[*].phone", Type: "scramble"},
+			{Path: "$.addresses[1]['post code']", Type: "mask"},
+			{Path: "$.total", Type: "round"},
+			{Path: "$.customer.missing", Type: "name"},
+		},
+	}
+	value := sqlparser.NewStrVal([]byte(`{"id": 5, "customer": {"name": "Zoë", "email": "zoe@example.com"}, "addresses": [{"phone": "+44 1632 960123"}, {"phone": null, "post code": "AB1 2CD"}], "total": 1234.5}`))
+	wants := `'{\"id\": 5, \"customer\": {\"name\": \"Zoë\", \"email\": \"aniya.jast@example.net\"}, \"addresses\": [{\"phone\": \"+40 8482 117232\"}, {\"phone\": null, \"post code\": \"*******\"}], \"total\": 1230.0}'`
+
+	result := sqlparser.String(newPseudonymizer(nil).transform(fieldPattern, value))
+	if result != wants {
+		t.Error("\nExpected:\n", wants, "\nActual:\n", result)
+	}
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// chunkKind tells apart the pieces a dump is split into.
+type chunkKind int
+
+const (
+	// chunkStatement is a statement along with its delimiter. When the rest of
+	// the statement's last line is only whitespace, that's included too.
+	chunkStatement chunkKind = iota
+	// chunkComment is a comment between statements.
+	chunkComment
+	// chunkWhitespace is whitespace between statements, up to the end of a line.
+	chunkWhitespace
+	// chunkDelimiter is a DELIMITER command of the mysql client, which changes
+	// what statements end with, such as around stored procedures.
+	chunkDelimiter
+	// chunkInsertHeader is the start of an INSERT statement up to its rows,
+	// which are read with nextBatch. It's only used when streaming rows.
+	chunkInsertHeader
+)
+
+// sqlChunk is a piece of the dump. Putting the text of every chunk back
+// together gives back the dump exactly as it was.
+type sqlChunk struct {
+	kind chunkKind
+	text string
+}
+
+var delimiterPattern = regexp.MustCompile(`(?i)^DELIMITER[ \t]+(\S+)`)
+
+// sqlScanner splits a dump into statements as it's read. Unlike splitting on
+// lines, it knows about quotes, escapes and comments, so a value containing a
+// semicolon or starting a line with "insert" can't throw it off.
+type sqlScanner struct {
+	r         *bufio.Reader
+	delimiter string
+	// streamRows splits INSERT statements into their header and batches of
+	// rows, rather than reading each statement whole
+	streamRows bool
+	// batchSize is roughly how much of an INSERT statement's rows are read at
+	// once when streaming rows
+	batchSize int
+	// lookahead is how many batches of rows are read ahead of being processed
+	lookahead int
+	// line is the part of the current line that hasn't been scanned yet. A
+	// line longer than the reader's buffer is read in several parts.
+	line string
+	// err is the error reading the next line, returned once everything read
+	// before it has been scanned
+	err error
+}
+
+// scanBufferSize is the most that's read from the input at once.
+const scanBufferSize = 2 * 1024 * 1024
+
+func newSQLScanner(input io.Reader) *sqlScanner {
+	return newSQLScannerSize(input, scanBufferSize)
+}
+
+func newSQLScannerSize(input io.Reader, size int) *sqlScanner {
+	return &sqlScanner{
+		r:         bufio.NewReaderSize(input, size),
+		delimiter: ";",
+		batchSize: streamBatchSize,
+		lookahead: streamLookahead,
+	}
+}
+
+// fill reads the next line, or as much of it as fits in the buffer, once the
+// current one has been scanned, returning false at the end of the input.
+func (s *sqlScanner) fill() bool {
+	if s.line != "" {
+		return true
+	}
+	if s.err != nil {
+		return false
+	}
+	line, err := s.r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		err = nil
+	}
+	s.line, s.err = string(line), err
+	return s.line != ""
+}
+
+// skipLine skips the rest of the current line, returning what was skipped.
+func (s *sqlScanner) skipLine() string {
+	var skipped strings.Builder
+	for s.fill() {
+		if end := strings.IndexByte(s.line, '\n'); end != -1 {
+			skipped.WriteString(s.line[:end+1])
+			s.line = s.line[end+1:]
+			break
+		}
+		skipped.WriteString(s.line)
+		s.line = ""
+	}
+	return skipped.String()
+}
+
+// next returns the next chunk of the dump, or io.EOF once it's all been read.
+func (s *sqlScanner) next() (sqlChunk, error) {
+	if !s.fill() {
+		return sqlChunk{}, s.err
+	}
+
+	line := s.line
+	switch {
+	case strings.IndexByte(" \t\r\n", line[0]) != -1:
+		end := len(line) - len(strings.TrimLeft(line, " \t\r\n"))
+		s.line = line[end:]
+		return sqlChunk{kind: chunkWhitespace, text: line[:end]}, nil
+
+	case line[0] == '#' || isDashComment(line):
+		return sqlChunk{kind: chunkComment, text: s.skipLine()}, nil
+
+	case strings.HasPrefix(line, "/*") && !strings.HasPrefix(line, "/*!"):
+		// Version comments such as /*!40101 SET NAMES utf8 */ are run by MySQL,
+		// so only other block comments are comments
+		return sqlChunk{kind: chunkComment, text: s.scanBlockComment()}, nil
+	}
+
+	if match := delimiterPattern.FindStringSubmatch(line); match != nil {
+		s.delimiter = match[1]
+		return sqlChunk{kind: chunkDelimiter, text: s.skipLine()}, nil
+	}
+
+	if s.streamRows {
+		if header := insertHeader(line); header != "" {
+			s.line = line[len(header):]
+			return sqlChunk{kind: chunkInsertHeader, text: header}, nil
+		}
+	}
+
+	return sqlChunk{kind: chunkStatement, text: s.scan(false)}, nil
+}
+
+// isDashComment reports whether the text starts with a -- comment, which MySQL
+// only treats as one when it's followed by whitespace.
+func isDashComment(text string) bool {
+	if !strings.HasPrefix(text, "--") {
+		return false
+	}
+	return len(text) == 2 || strings.IndexByte(" \t\r\n", text[2]) != -1
+}
+
+func (s *sqlScanner) scanBlockComment() string {
+	var comment strings.Builder
+	for s.fill() {
+		// Skip the opening /* so that /*/ doesn't count as a whole comment
+		start := 0
+		if comment.Len() == 0 {
+			start = 2
+		}
+		if end := strings.Index(s.line[start:], "*/"); end != -1 {
+			end += start + 2
+			comment.WriteString(s.line[:end])
+			s.line = s.line[end:]
+			return comment.String()
+		}
+		comment.WriteString(s.line)
+		s.line = ""
+	}
+	return comment.String()
+}
+
+// scan reads up to the end of the statement's delimiter. A statement missing
+// its delimiter at the end of the dump runs to the end. When reading a row,
+// it stops at the parenthesis closing the row instead.
+func (s *sqlScanner) scan(row bool) string {
+	var statement strings.Builder
+	var quote byte
+	inComment, inLineComment, escaped := false, false, false
+	depth := 0
+
+	for s.fill() {
+		line := s.line
+		i := 0
+		for i < len(line) {
+			char := line[i]
+			switch {
+			case escaped:
+				// The character after a backslash at the end of the last part read
+				escaped = false
+				i++
+
+			case inLineComment:
+				end := strings.IndexByte(line[i:], '\n')
+				if end == -1 {
+					i = len(line)
+					continue
+				}
+				i += end + 1
+				inLineComment = false
+
+			case inComment:
+				end := strings.Index(line[i:], "*/")
+				if end == -1 {
+					i = len(line)
+					continue
+				}
+				i += end + 2
+				inComment = false
+
+			case quote != 0:
+				// Skip straight to the next character that could end the string
+				special := string(quote)
+				if quote != '`' {
+					special += `\`
+				}
+				next := strings.IndexAny(line[i:], special)
+				if next == -1 {
+					i = len(line)
+					continue
+				}
+				i += next
+				switch {
+				case line[i] == '\\':
+					escaped = i+1 == len(line)
+					i += 2
+				case i+1 < len(line) && line[i+1] == quote:
+					// A doubled quote is an escaped quote
+					i += 2
+				default:
+					quote = 0
+					i++
+				}
+
+			case char == '\'' || char == '"' || char == '`':
+				quote = char
+				i++
+
+			case char == '#' || (char == '-' && isDashComment(line[i:])):
+				inLineComment = true
+				i++
+
+			case char == '/' && strings.HasPrefix(line[i:], "/*"):
+				inComment = true
+				i += 2
+
+			case row && char == '(':
+				depth++
+				i++
+
+			case row && char == ')':
+				depth--
+				i++
+				if depth == 0 {
+					statement.WriteString(line[:i])
+					s.line = line[i:]
+					return statement.String()
+				}
+
+			case !row && char == s.delimiter[0] && strings.HasPrefix(line[i:], s.delimiter):
+				end := i + len(s.delimiter)
+				if strings.TrimSpace(line[end:]) == "" {
+					end = len(line)
+				}
+				statement.WriteString(line[:end])
+				s.line = line[end:]
+				return statement.String()
+
+			default:
+				i++
+			}
+		}
+		statement.WriteString(line)
+		s.line = ""
+	}
+	return statement.String()
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSQLScanner(t *testing.T) {
+
+	tests := []struct {
+		name  string
+		dump  string
+		wants []sqlChunk
+	}{
+		{
+			name: "delimiter in a value",
+			dump: "INSERT INTO `wp_posts` VALUES (1,'foo;\nINSERT bar'),(2,'it''s \\';');\n",
+			wants: []sqlChunk{
+				{chunkStatement, "INSERT INTO `wp_posts` VALUES (1,'foo;\nINSERT bar'),(2,'it''s \\';');\n"},
+			},
+		},
+		{
+			name: "several statements on a line",
+			dump: "insert into foo values (1); replace into foo values (2);  \n",
+			wants: []sqlChunk{
+				{chunkStatement, "insert into foo values (1);"},
+				{chunkWhitespace, " "},
+				{chunkStatement, "replace into foo values (2);  \n"},
+			},
+		},
+		{
+			name: "comments",
+			dump: "-- MySQL dump\n\n/* multi\nline; */\n/*!40101 SET NAMES utf8 */;\nINSERT INTO foo VALUES (1 /* ; */, 2) -- ;\n;\n",
+			wants: []sqlChunk{
+				{chunkComment, "-- MySQL dump\n"},
+				{chunkWhitespace, "\n"},
+				{chunkComment, "/* multi\nline; */"},
+				{chunkWhitespace, "\n"},
+				{chunkStatement, "/*!40101 SET NAMES utf8 */;\n"},
+				{chunkStatement, "INSERT INTO foo VALUES (1 /* ; */, 2) -- ;\n;\n"},
+			},
+		},
+		{
+			name: "delimiter changes",
+			dump: "DELIMITER ;;\nCREATE TRIGGER foo BEFORE INSERT ON bar FOR EACH ROW BEGIN SET NEW.a = 1; END ;;\nDELIMITER ;\n",
+			wants: []sqlChunk{
+				{chunkDelimiter, "DELIMITER ;;\n"},
+				{chunkStatement, "CREATE TRIGGER foo BEFORE INSERT ON bar FOR EACH ROW BEGIN SET NEW.a = 1; END ;;\n"},
+				{chunkDelimiter, "DELIMITER ;\n"},
+			},
+		},
+		{
+			name: "missing delimiter",
+			dump: "INSERT INTO foo VALUES (1)",
+			wants: []sqlChunk{
+				{chunkStatement, "INSERT INTO foo VALUES (1)"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			scanner := newSQLScanner(strings.NewReader(test.dump))
+
+			var chunks []sqlChunk
+			for {
+				chunk, err := scanner.next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				chunks = append(chunks, chunk)
+			}
+
+			if !reflect.DeepEqual(chunks, test.wants) {
+				t.Errorf("\nExpected:\n%+v\nActual:\n%+v", test.wants, chunks)
+			}
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"sync"
+)
+
+// pipeline processes statements on a fixed number of workers, handing their
+// results back in the order the statements came in.
+type pipeline struct {
+	// lines is where the results come out, in order. It's closed once every
+	// statement has been processed, or the first error has been hit.
+	lines chan string
+
+	// queue holds the statements in the order they came in, so its capacity
+	// caps how many are waiting, being processed or waiting to be written out
+	queue   chan *job
+	work    chan *job
+	workers sync.WaitGroup
+	// inFlight tracks the statements that haven't been processed yet
+	inFlight sync.WaitGroup
+
+	mutex   sync.Mutex
+	failure error
+	done    chan struct{}
+}
+
+// job is a statement waiting to be processed, or the result of one.
+type job struct {
+	process func() (string, error)
+	result  chan string
+	err     error
+}
+
+func newPipeline(workers, queueSize int) *pipeline {
+	p := &pipeline{
+		lines: make(chan string),
+		queue: make(chan *job, queueSize),
+		work:  make(chan *job),
+		done:  make(chan struct{}),
+	}
+
+	p.workers.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer p.workers.Done()
+			for j := range p.work {
+				text, err := j.process()
+				j.err = err
+				j.result <- text
+				p.inFlight.Done()
+			}
+		}()
+	}
+
+	go p.reassemble()
+
+	return p
+}
+
+// reassemble writes out the results in the order the statements came in, as
+// each one is ready. Nothing is written out after an error, so the output
+// stops at the last statement that was processed successfully.
+func (p *pipeline) reassemble() {
+	defer close(p.lines)
+	for j := range p.queue {
+		text := <-j.result
+		if j.err != nil {
+			p.fail(j.err)
+		}
+		if p.failed() {
+			// Keep draining the queue so nothing sending to it gets stuck
+			continue
+		}
+		p.lines <- text
+	}
+}
+
+// process queues up a statement to be processed by the next free worker. It
+// blocks while the queue is full.
+func (p *pipeline) process(process func() (string, error)) {
+	j := &job{process: process, result: make(chan string, 1)}
+	p.inFlight.Add(1)
+	p.queue <- j
+	p.work <- j
+}
+
+// passThrough queues up text that's written out as it is.
+func (p *pipeline) passThrough(text string) {
+	j := &job{result: make(chan string, 1)}
+	j.result <- text
+	p.queue <- j
+}
+
+// stop queues up an error that stops the rest of the input being processed,
+// once everything queued before it has been written out.
+func (p *pipeline) stop(err error) {
+	j := &job{result: make(chan string, 1), err: err}
+	j.result <- ""
+	p.queue <- j
+}
+
+// wait blocks until every statement queued so far has been processed.
+func (p *pipeline) wait() {
+	p.inFlight.Wait()
+}
+
+// close stops the workers once they've processed the statements queued up, and
+// closes lines once the results have all been written out.
+func (p *pipeline) close() {
+	close(p.work)
+	p.workers.Wait()
+	close(p.queue)
+}
+
+// fail records the error that stopped the output. Only the first one is kept,
+// as the errors after it are most likely caused by it.
+func (p *pipeline) fail(err error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.failure == nil {
+		p.failure = err
+		close(p.done)
+	}
+}
+
+// failed reports whether the pipeline has hit an error, so that there's no
+// point reading any more of the input.
+func (p *pipeline) failed() bool {
+	select {
+	case <-p.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// err returns the first error hit, if any. Check it once lines is closed.
+func (p *pipeline) err() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.failure
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPipeline(t *testing.T) {
+	tests := []struct {
+		name    string
+		workers int
+		fail    int
+		want    []string
+		wantErr bool
+	}{
+		{
+			name:    "one worker",
+			workers: 1,
+			fail:    -1,
+			want:    []string{"header", "0", "1", "2", "3", "4", "5", "footer"},
+		},
+		{
+			name:    "several workers",
+			workers: 3,
+			fail:    -1,
+			want:    []string{"header", "0", "1", "2", "3", "4", "5", "footer"},
+		},
+		{
+			name:    "failed statement",
+			workers: 3,
+			fail:    3,
+			want:    []string{"header", "0", "1", "2"},
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := newPipeline(test.workers, 2)
+			go func() {
+				p.passThrough("header")
+				for i := 0; i < 6; i++ {
+					i := i
+					p.process(func() (string, error) {
+						// The first statements take the longest, so they finish last
+						time.Sleep(time.Duration(6-i) * time.Millisecond)
+						if i == test.fail {
+							return "", errors.New("failed")
+						}
+						return fmt.Sprint(i), nil
+					})
+				}
+				p.passThrough("footer")
+				p.close()
+			}()
+
+			var got []string
+			for line := range p.lines {
+				got = append(got, line)
+			}
+
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+			if err := p.err(); (err != nil) != test.wantErr {
+				t.Errorf("got error %v, want error %v", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestPipelineStop(t *testing.T) {
+	p := newPipeline(2, 2)
+	go func() {
+		p.process(func() (string, error) {
+			time.Sleep(time.Millisecond)
+			return "kept", nil
+		})
+		p.stop(errors.New("failed reading input"))
+		p.passThrough("dropped")
+		p.close()
+	}()
+
+	var got []string
+	for line := range p.lines {
+		got = append(got, line)
+	}
+
+	if want := []string{"kept"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if err := p.err(); err == nil || err.Error() != "failed reading input" {
+		t.Errorf("got error %v, want failed reading input", err)
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"github.com/xwb1989/sqlparser"
+	"io/ioutil"
+	"math"
+	"os"
+	"strings"
+	"sync"
+	"syreclabs.com/go/faker"
+)
+
+// keyEnvVar is the environment variable the secret key is read from when
+// neither --key nor --key-file is provided.
+const keyEnvVar = "ANONYMIZE_MYSQLDUMP_KEY"
+
+// readKey returns the secret key used for deterministic pseudonymisation,
+// taken from the --key flag, the file given to --key-file or the
+// ANONYMIZE_MYSQLDUMP_KEY environment variable, in that order. A nil key means
+// values are replaced with random ones.
+func readKey(key string, keyFilePath string) ([]byte, error) {
+	if key != "" {
+		return []byte(key), nil
+	}
+
+	if keyFilePath != "" {
+		contents, err := ioutil.ReadFile(keyFilePath)
+		if err != nil {
+			return nil, err
+		}
+		// Editors love adding a trailing newline, which shouldn't be part of the key
+		return []byte(strings.TrimRight(string(contents), "\r\n")), nil
+	}
+
+	if key, ok := os.LookupEnv(keyEnvVar); ok && key != "" {
+		return []byte(key), nil
+	}
+
+	return nil, nil
+}
+
+// Pseudonymizer replaces values using the transformation functions, making
+// sure fields sharing a consistency key give the same original value the same
+// replacement within a dump.
+type Pseudonymizer struct {
+	key []byte
+	*sharedReplacements
+
+	// fake generates the fake values. Each statement, and each chunk of a
+	// statement's rows, gets a generator of its own, seeded from where it is in
+	// the input, so that the replacements don't depend on the order statements
+	// and chunks happen to be transformed in.
+	fake *fakeGenerator
+	// seeded generates the values seeded from the original value instead, so
+	// that whether an earlier replacement is reused doesn't change the values
+	// fake generates next
+	seeded *fakeGenerator
+	// statement is the position in the input of the statement being
+	// transformed
+	statement int64
+
+	// chunk is set on the pseudonymizers handed out to transform the rows of a
+	// statement a chunk at a time
+	chunk *rowChunk
+}
+
+// sharedReplacements are the replacements made so far, shared by every
+// statement and chunk.
+type sharedReplacements struct {
+	// salt seeds the generators of every statement and chunk, and without a key,
+	// the values of fields with a consistency key, so they get the same
+	// replacement wherever they turn up. It's drawn from faker's random source,
+	// so seeding faker still makes a run repeatable.
+	salt []byte
+
+	// dictionary is set when free text is scrubbed of the names replaced
+	// elsewhere in the dump, which is the only time they need remembering
+	dictionary bool
+	// names remembers the names, usernames and emails replaced so far, so that
+	// mentions of them in free text can be given the same replacement
+	namesMu sync.RWMutex
+	names   map[string]string
+	// nameIndex holds the remembered names by their first word, so that looking
+	// for them in free text only takes a lookup for each word of the text
+	nameIndex map[string][]string
+}
+
+// rowChunk is the state of its own that a chunk of rows is transformed with.
+type rowChunk struct {
+	// names are the names remembered by the chunk, added to the dictionary once
+	// every chunk is done
+	names map[string]string
+}
+
+func newPseudonymizer(key []byte) *Pseudonymizer {
+	salt := make([]byte, 8)
+	binary.BigEndian.PutUint64(salt, uint64(faker.RandomInt64(0, math.MaxInt64-1)))
+
+	p := &Pseudonymizer{
+		key: key,
+		sharedReplacements: &sharedReplacements{
+			salt:      salt,
+			names:     make(map[string]string),
+			nameIndex: make(map[string][]string),
+		},
+	}
+	// Statements get generators of their own from forStatement, so this one
+	// is only used for values transformed outside of any statement
+	p.fake = newFakeGenerator(p.positionSeed(-1, 0))
+	return p
+}
+
+// forStatement returns a pseudonymizer for the statement at the given
+// position in the input.
+func (p *Pseudonymizer) forStatement(statement int64) *Pseudonymizer {
+	return &Pseudonymizer{
+		key:                p.key,
+		sharedReplacements: p.sharedReplacements,
+		fake:               newFakeGenerator(p.positionSeed(statement, 0)),
+		statement:          statement,
+	}
+}
+
+// split returns a pseudonymizer for each of the chunks of the statement's
+// rows. How many of them are transformed at once makes no difference to the
+// replacements, as each chunk is seeded from its position in the statement.
+func (p *Pseudonymizer) split(chunks int) []*Pseudonymizer {
+	split := make([]*Pseudonymizer, chunks)
+	for i := range split {
+		split[i] = &Pseudonymizer{
+			key:                p.key,
+			sharedReplacements: p.sharedReplacements,
+			fake:               newFakeGenerator(p.positionSeed(p.statement, int64(i+1))),
+			statement:          p.statement,
+			chunk:              &rowChunk{names: make(map[string]string)},
+		}
+	}
+	return split
+}
+
+// positionSeed derives the seed of the generator of a statement, or of a
+// chunk of its rows, from their position in the input.
+func (p *Pseudonymizer) positionSeed(statement, chunk int64) int64 {
+	position := make([]byte, 16)
+	binary.BigEndian.PutUint64(position, uint64(statement))
+	binary.BigEndian.PutUint64(position[8:], uint64(chunk))
+	return deterministicSeed(p.salt, "position", position)
+}
+
+// merge adds the names remembered by the chunks to the dictionary, in the
+// order of the chunks, as if the rows had been transformed one after another.
+func (p *Pseudonymizer) merge(split []*Pseudonymizer) {
+	for _, chunk := range split {
+		for original, replacement := range chunk.chunk.names {
+			p.remember(original, replacement)
+		}
+	}
+}
+
+// transform returns the replacement for a value of the given field.
+func (p *Pseudonymizer) transform(fieldPattern PatternField, value *sqlparser.SQLVal) *sqlparser.SQLVal {
+	switch fieldPattern.Type {
+	case serializedType:
+		return p.transformSerialized(fieldPattern, value)
+	case jsonType:
+		return p.transformJSON(fieldPattern, value)
+	case scrubType:
+		return p.scrub(fieldPattern, value)
+	}
+
+	replacement := p.replace(fieldPattern, value)
+	if p.dictionary && dictionaryTypes[fieldPattern.Type] {
+		p.remember(literalString(value), literalString(replacement))
+	}
+	return replacement
+}
+
+// replace returns the replacement for a value of a field with a plain
+// transformation type. The replacements of fields with a consistency key are
+// seeded from the original value, so the same value always gets the same
+// replacement without any of them having to be remembered.
+func (p *Pseudonymizer) replace(fieldPattern PatternField, value *sqlparser.SQLVal) *sqlparser.SQLVal {
+	domain := fieldPattern.Type
+	if fieldPattern.ConsistencyKey != "" {
+		domain = fieldPattern.ConsistencyKey
+	}
+	return p.generate(fieldPattern, domain, value)
+}
+
+// generate runs the transformation function registered for the field's type.
+// When a key is provided, the generator is seeded with an HMAC of the original
+// value first, so the same value always gets the same replacement across rows
+// and runs, while the original can't be worked out without the key. Without a
+// key, values with a consistency key are seeded the same way from the run's
+// salt instead.
+func (p *Pseudonymizer) generate(fieldPattern PatternField, domain string, value *sqlparser.SQLVal) *sqlparser.SQLVal {
+	transform := transformationFunctionMap[fieldPattern.Type]
+
+	fake := p.fake
+	if p.key != nil || fieldPattern.ConsistencyKey != "" {
+		if p.seeded == nil {
+			p.seeded = newFakeGenerator(0)
+		}
+		fake = p.seeded
+		if p.key != nil {
+			fake.seed(deterministicSeed(p.key, domain, value.Val))
+		} else {
+			fake.seed(deterministicSeed(p.salt, domain, value.Val))
+		}
+	}
+
+	if fieldPattern.Options.Locale != "" {
+		defaultLocale := fake.locale
+		fake.locale = fakerLocales[strings.ToLower(fieldPattern.Options.Locale)]
+		defer func() {
+			fake.locale = defaultLocale
+		}()
+	}
+
+	return transform(fake, value, fieldPattern.Options)
+}
+
+// deterministicSeed derives a seed from the original value. The domain, which
+// is either the field's consistency key or its transformation type, is
+// included so that, say, a first name and a username with the same original
+// value don't end up with related replacements.
+func deterministicSeed(key []byte, domain string, original []byte) int64 {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(domain))
+	mac.Write([]byte{0})
+	mac.Write(original)
+	sum := mac.Sum(nil)
+	return int64(binary.BigEndian.Uint64(sum[:8]))
+}
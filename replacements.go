@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"github.com/xwb1989/sqlparser"
+	"strings"
+)
+
+// Replacement rewrites every occurrence of a string in the dump's values, such
+// as the site's production URL.
+type Replacement struct {
+	Search  string `json:"search"`
+	Replace string `json:"replace"`
+}
+
+func (r Replacement) validate() error {
+	if r.Search == "" {
+		return fmt.Errorf("replacement requires a search string")
+	}
+	return nil
+}
+
+// searchReplacer rewrites strings the way PHP-serialized data needs it, so that
+// the byte lengths of serialized strings stay valid.
+type searchReplacer struct {
+	searches []string
+	replacer *strings.Replacer
+}
+
+// newSearchReplacer returns nil when there's nothing to replace.
+func newSearchReplacer(replacements []Replacement) *searchReplacer {
+	if len(replacements) == 0 {
+		return nil
+	}
+
+	r := &searchReplacer{}
+	var pairs []string
+	for _, replacement := range replacements {
+		r.searches = append(r.searches, replacement.Search)
+		pairs = append(pairs, replacement.Search, replacement.Replace)
+	}
+	// Searches are applied all at once rather than one after the other, so one
+	// replacement can't be rewritten again by the next
+	r.replacer = strings.NewReplacer(pairs...)
+	return r
+}
+
+// applyReplacements rewrites the strings of every row.
+func applyReplacements(values sqlparser.Values, r *searchReplacer) {
+	for _, row := range values {
+		for i, expr := range row {
+			value, ok := expr.(*sqlparser.SQLVal)
+			if !ok || value.Type != sqlparser.StrVal {
+				continue
+			}
+			text := string(value.Val)
+			if replaced := r.replace(text); replaced != text {
+				row[i] = sqlparser.NewStrVal([]byte(replaced))
+			}
+		}
+	}
+}
+
+// replace rewrites a string, which can be PHP-serialized, in which case the
+// strings inside it are rewritten and their lengths updated.
+func (r *searchReplacer) replace(text string) string {
+	if !r.contains(text) {
+		return text
+	}
+
+	parsed, err := unserializePHP(text)
+	if err != nil {
+		return r.replacer.Replace(text)
+	}
+	r.replaceSerialized(parsed)
+	return parsed.serialize()
+}
+
+func (r *searchReplacer) replaceSerialized(value *phpValue) {
+	switch value.kind {
+	case 's':
+		// Serialized data is sometimes serialized again, so look inside strings too
+		value.raw = r.replace(value.raw)
+	case 'a', 'O':
+		for _, entry := range value.entries {
+			r.replaceSerialized(entry.key)
+			r.replaceSerialized(entry.value)
+		}
+	}
+}
+
+// contains saves parsing values that have nothing to replace, which is most of
+// them.
+func (r *searchReplacer) contains(text string) bool {
+	for _, search := range r.searches {
+		if strings.Contains(text, search) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestSearchReplacer(t *testing.T) {
+
+	replacer := newSearchReplacer([]Replacement{
+		{Search: "https://www.client.com", Replace: "https://client.staging.local"},
+		{Search: "client.com", Replace: "client.local"},
+	})
+
+	var tests = []struct {
+		testName string
+		text     string
+		wants    string
+	}{
+		{
+			testName: "plain text",
+			text:     `<a href="https://www.client.com/about">About</a>, mail hello@client.com`,
+			wants:    `<a href="https://client.staging.local/about">About</a>, mail hello@client.local`,
+		},
+		{
+			testName: "nothing to replace",
+			text:     `s:11:"example.com";`,
+			wants:    `s:11:"example.com";`,
+		},
+		{
+			testName: "serialized",
+			text:     `a:2:{s:4:"home";s:22:"https://www.client.com";s:22:"https://www.client.com";i:1;}`,
+			wants:    `a:2:{s:4:"home";s:28:"https://client.staging.local";s:28:"https://client.staging.local";i:1;}`,
+		},
+		{
+			testName: "serialized twice",
+			text:     `a:1:{i:0;s:30:"s:22:"https://www.client.com";";}`,
+			wants:    `a:1:{i:0;s:36:"s:28:"https://client.staging.local";";}`,
+		},
+		{
+			testName: "broken serialized data",
+			text:     `s:5:"https://www.client.com";`,
+			wants:    `s:5:"https://client.staging.local";`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.testName, func(t *testing.T) {
+			result := replacer.replace(test.text)
+			if result != test.wants {
+				t.Error("\nExpected:\n", test.wants, "\nActual:\n", result)
+			}
+		})
+	}
+}
+
+func TestReplacementsInsert(t *testing.T) {
+
+	config := Config{
+		Replacements: []Replacement{
+			{Search: "https://www.client.com", Replace: "https://client.staging.local"},
+		},
+	}
+	query := "INSERT INTO `wp_options` VALUES (1,'siteurl','https://www.client.com','yes'),(2,'widget','a:1:{s:3:\\\"url\\\";s:27:\\\"https://www.client.com/shop\\\";}','yes');\n"
+	wants := "insert into wp_options values (1, 'siteurl', 'https://client.staging.local', 'yes'), (2, 'widget', 'a:1:{s:3:\\\"url\\\";s:33:\\\"https://client.staging.local/shop\\\";}', 'yes');\n"
+
+	result := processString(t, config, query)
+
+	if result != wants {
+		t.Error("\nExpected:\n", wants, "\nActual:\n", result)
+	}
+}
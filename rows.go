@@ -0,0 +1,17 @@
+package main
+
+import (
+	"github.com/xwb1989/sqlparser"
+)
+
+// deleteRows drops the rows matching all of the constraints, such as the
+// session tokens stored in wp_usermeta.
+func deleteRows(values sqlparser.Values, constraints []PatternFieldConstraint, columns map[string]int) sqlparser.Values {
+	kept := values[:0]
+	for _, row := range values {
+		if !rowMatchesAll(constraints, row, columns) {
+			kept = append(kept, row)
+		}
+	}
+	return kept
+}
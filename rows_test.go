@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestDeleteRows(t *testing.T) {
+
+	config := Config{
+		Patterns: []ConfigPattern{
+			{
+				TableName: "wp_usermeta",
+				DeleteRows: []PatternFieldConstraint{
+					{Field: "meta_key", Value: "session_tokens"},
+				},
+			},
+			{
+				TableName: "wp_options",
+				DeleteRows: []PatternFieldConstraint{
+					{
+						Any: []PatternFieldConstraint{
+							{Field: "option_name", Operator: "like", Value: "\\_transient\\_%"},
+							{Field: "option_name", Operator: "like", Value: "\\_site\\_transient\\_%"},
+						},
+					},
+				},
+			},
+		},
+	}
+	query := "INSERT INTO `wp_usermeta` (`umeta_id`, `user_id`, `meta_key`, `meta_value`) VALUES (1,1,'nickname','admin'),(2,1,'session_tokens','a:0:{}'),(3,2,'session_tokens','a:0:{}');\n" +
+		"INSERT INTO `wp_options` (`option_id`, `option_name`, `option_value`) VALUES (1,'_transient_doing_cron','1564555155'),(2,'_site_transient_timeout_theme_roots','1564556955');\n" +
+		"INSERT INTO `wp_options` (`option_id`, `option_name`, `option_value`) VALUES (3,'siteurl','https://www.client.com');\n"
+	wants := "insert into wp_usermeta(umeta_id, user_id, meta_key, meta_value) values (1, 1, 'nickname', 'admin');\n" +
+		"insert into wp_options(option_id, option_name, option_value) values (3, 'siteurl', 'https://www.client.com');\n"
+
+	result := processString(t, config, query)
+
+	if result != wants {
+		t.Error("\nExpected:\n", wants, "\nActual:\n", result)
+	}
+}
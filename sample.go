@@ -0,0 +1,143 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"github.com/xwb1989/sqlparser"
+	"strconv"
+)
+
+// SampleConfig keeps a portion of a table's rows, such as for performance
+// testing with smaller but representative data.
+type SampleConfig struct {
+	// Percent is the share of the rows kept.
+	Percent float64 `json:"percent"`
+	// Every keeps every Nth row, in the order they appear in the dump.
+	Every int `json:"every"`
+	// Key is the column whose value decides whether a row is kept when sampling
+	// by percentage, so that tables sampled by the same values with the same
+	// seed keep the rows related to each other.
+	Key string `json:"key"`
+	// Seed picks which rows are kept when sampling by percentage.
+	Seed int64 `json:"seed"`
+}
+
+func (s *SampleConfig) validate() error {
+	switch {
+	case s.Percent != 0 && s.Every != 0:
+		return fmt.Errorf("sample can either have a percent or every option, not both")
+	case s.Every < 0:
+		return fmt.Errorf("sample every option can't be negative")
+	case s.Every > 0 && s.Key != "":
+		return fmt.Errorf("sample key option only works with the percent option")
+	case s.Every == 0 && (s.Percent <= 0 || s.Percent > 100):
+		return fmt.Errorf("sample percent option has to be above 0 and at most 100")
+	}
+	return nil
+}
+
+// sampleFor returns how the table is sampled, if it is.
+func (c Config) sampleFor(table string) *SampleConfig {
+	var sample *SampleConfig
+	for _, pattern := range c.Patterns {
+		if pattern.TableName == table && pattern.Sample != nil {
+			sample = pattern.Sample
+		}
+	}
+	return sample
+}
+
+// keepRows drops the rows left out of the sample. Sampling every Nth row needs
+// the offset of the statement's first row within the table.
+func (s *SampleConfig) keepRows(table string, values sqlparser.Values, columns map[string]int, offset *rowOffset) (sqlparser.Values, error) {
+	keyIndex := -1
+	if s.Key != "" {
+		index, err := resolveColumnIndex(s.Key, 0, columns)
+		if err != nil {
+			return values, fmt.Errorf("can't find column %s of %s for sampling: %s", s.Key, table, err)
+		}
+		keyIndex = index
+	}
+
+	start := offset.count(len(values))
+
+	kept := values[:0]
+	for i, row := range values {
+		var keep bool
+		switch {
+		case s.Every > 0:
+			keep = (start+i)%s.Every == 0
+		case keyIndex >= 0 && keyIndex < len(row):
+			value, isNull := exprToString(row[keyIndex])
+			keep = !isNull && s.sampled(value)
+		default:
+			// Rows are told apart by their contents, so the same row is kept on
+			// every run
+			keep = s.sampled(table + "\x00" + sqlparser.String(row))
+		}
+		if keep {
+			kept = append(kept, row)
+		}
+	}
+	return kept, nil
+}
+
+// sampled reports whether the hash of the value falls within the percentage
+// of rows kept.
+func (s *SampleConfig) sampled(value string) bool {
+	hash := sha256.Sum256([]byte(strconv.FormatInt(s.Seed, 10) + "\x00" + value))
+	fraction := float64(binary.BigEndian.Uint64(hash[:8])>>11) / (1 << 53)
+	return fraction*100 < s.Percent
+}
+
+// rowOffset hands the number of rows of a table seen so far from one statement
+// to the next, so rows can be counted in the order they appear in the dump
+// while the statements are processed at the same time.
+type rowOffset struct {
+	previous <-chan int
+	next     chan<- int
+	counted  bool
+}
+
+// count returns the number of rows of the table before the statement's, once
+// the statements before it have been counted.
+func (o *rowOffset) count(rows int) int {
+	if o == nil {
+		return 0
+	}
+	start := <-o.previous
+	o.next <- start + rows
+	o.counted = true
+	return start
+}
+
+// release lets the next statement go ahead when the statement wasn't counted,
+// such as when it failed to parse.
+func (o *rowOffset) release() {
+	if o != nil && !o.counted {
+		o.count(0)
+	}
+}
+
+// rowCounter hands out the offsets of the statements of each table.
+type rowCounter struct {
+	last map[string]chan int
+}
+
+func newRowCounter() *rowCounter {
+	return &rowCounter{last: make(map[string]chan int)}
+}
+
+// next returns the offset for the table's next statement. It has to be called
+// in the order the statements appear in the dump.
+func (c *rowCounter) next(table string) *rowOffset {
+	previous, ok := c.last[table]
+	if !ok {
+		previous = make(chan int, 1)
+		previous <- 0
+	}
+	next := make(chan int, 1)
+	c.last[table] = next
+	return &rowOffset{previous: previous, next: next}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestSampleEvery(t *testing.T) {
+
+	config := Config{
+		Patterns: []ConfigPattern{
+			{TableName: "wp_posts", Sample: &SampleConfig{Every: 3}},
+		},
+	}
+	query := "INSERT INTO `wp_posts` (`ID`) VALUES (1),(2),(3),(4);\n" +
+		"INSERT INTO `wp_posts` (`ID`) VALUES (5),(6),(7);\n" +
+		"INSERT INTO `wp_posts` (`ID`) VALUES (8),(9);\n" +
+		"INSERT INTO `wp_posts` (`ID`) VALUES (10);\n"
+	wants := "insert into wp_posts(ID) values (1), (4);\n" +
+		"insert into wp_posts(ID) values (7);\n" +
+		"insert into wp_posts(ID) values (10);\n"
+
+	result := processString(t, config, query)
+
+	if result != wants {
+		t.Error("\nExpected:\n", wants, "\nActual:\n", result)
+	}
+}
+
+func TestSamplePercent(t *testing.T) {
+
+	config := Config{
+		Patterns: []ConfigPattern{
+			{TableName: "wp_posts", Sample: &SampleConfig{Percent: 20, Key: "ID", Seed: 42}},
+			{TableName: "wp_postmeta", Sample: &SampleConfig{Percent: 20, Key: "post_id", Seed: 42}},
+		},
+	}
+
+	var posts, postmeta []string
+	for id := 1; id <= 1000; id++ {
+		posts = append(posts, fmt.Sprintf("(%d)", id))
+		postmeta = append(postmeta, fmt.Sprintf("(%d)", id))
+	}
+	query := "INSERT INTO `wp_posts` (`ID`) VALUES " + strings.Join(posts, ",") + ";\n" +
+		"INSERT INTO `wp_postmeta` (`post_id`) VALUES " + strings.Join(postmeta, ",") + ";\n"
+
+	// Each statement comes out on a line of its own
+	first := strings.Split(strings.TrimSpace(processString(t, config, query)), "\n")
+	if len(first) != 2 {
+		t.Fatal("Expected both statements to be kept, got", first)
+	}
+
+	// Posts and their meta are sampled by the same values, so the same rows are
+	// kept in both tables
+	keptPosts := strings.TrimPrefix(first[0], "insert into wp_posts(ID) values ")
+	keptPostmeta := strings.TrimPrefix(first[1], "insert into wp_postmeta(post_id) values ")
+	if keptPosts != keptPostmeta {
+		t.Error("\nExpected the same rows to be kept, got:\n", keptPosts, "\nand:\n", keptPostmeta)
+	}
+
+	if kept := strings.Count(keptPosts, "("); kept < 150 || kept > 250 {
+		t.Error("Expected around 200 of 1000 rows to be kept, got", kept)
+	}
+
+	if second := strings.Split(strings.TrimSpace(processString(t, config, query)), "\n"); strings.Join(second, "") != strings.Join(first, "") {
+		t.Error("Expected the same rows to be kept on every run")
+	}
+}
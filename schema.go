@@ -0,0 +1,203 @@
+package main
+
+import (
+	"fmt"
+	"github.com/xwb1989/sqlparser"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// TableSchemas keeps track of the columns of every table whose CREATE TABLE
+// statement has been read from the dump so far. mysqldump writes the CREATE
+// TABLE statement right before a table's data, so by the time an INSERT comes
+// through we know which index every column name maps to.
+type TableSchemas struct {
+	mu     sync.RWMutex
+	tables map[string]map[string]int
+}
+
+func newTableSchemas() *TableSchemas {
+	return &TableSchemas{
+		tables: make(map[string]map[string]int),
+	}
+}
+
+// addFromCreateTable parses a CREATE TABLE statement and records the 0-based
+// index of each of its columns.
+func (s *TableSchemas) addFromCreateTable(query string) error {
+	table, columns, err := parseCreateTable(query)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tables[table] = columns
+	return nil
+}
+
+// columnsFor returns the column name to index map for the given table, or nil
+// if we haven't seen the table's CREATE TABLE statement.
+func (s *TableSchemas) columnsFor(table string) map[string]int {
+	if s == nil {
+		return nil
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.tables[table]
+}
+
+func parseCreateTable(query string) (string, map[string]int, error) {
+	stmt, err := sqlparser.Parse(query)
+	if err != nil {
+		// sqlparser doesn't understand every column definition MySQL does, so
+		// fall back to reading the column names from mysqldump's layout
+		return parseCreateTableLines(query)
+	}
+
+	ddl, isDDL := stmt.(*sqlparser.DDL)
+	if !isDDL || ddl.Action != sqlparser.CreateStr || ddl.TableSpec == nil {
+		return "", nil, fmt.Errorf("not a CREATE TABLE statement")
+	}
+
+	columns := make(map[string]int)
+	for i, column := range ddl.TableSpec.Columns {
+		columns[column.Name.Lowered()] = i
+	}
+	return ddl.NewName.Name.String(), columns, nil
+}
+
+// parseCreateTableLines reads a CREATE TABLE statement as formatted by
+// mysqldump, where the table name and every column name are quoted with
+// backticks and each column definition sits on its own line.
+func parseCreateTableLines(query string) (string, map[string]int, error) {
+	lines := strings.Split(query, "\n")
+
+	table := backtickedName(lines[0])
+	if table == "" {
+		return "", nil, fmt.Errorf("unable to find table name in CREATE TABLE statement")
+	}
+
+	columns := make(map[string]int)
+	for _, line := range lines[1:] {
+		line = strings.TrimSpace(line)
+		// Index and constraint definitions never start with a backtick
+		if !strings.HasPrefix(line, "`") {
+			continue
+		}
+		columns[strings.ToLower(backtickedName(line))] = len(columns)
+	}
+
+	if len(columns) == 0 {
+		return "", nil, fmt.Errorf("unable to find columns in CREATE TABLE statement for %s", table)
+	}
+	return table, columns, nil
+}
+
+// backtickedName returns the first backtick quoted identifier in the line.
+func backtickedName(line string) string {
+	start := strings.Index(line, "`")
+	if start == -1 {
+		return ""
+	}
+	end := strings.Index(line[start+1:], "`")
+	if end == -1 {
+		return ""
+	}
+	return line[start+1 : start+1+end]
+}
+
+var createTablePattern = regexp.MustCompile(`(?i)^CREATE\s+TABLE`)
+
+var insertTablePattern = regexp.MustCompile("(?i)^\\s*(?:INSERT|REPLACE)\\s+(?:(?:LOW_PRIORITY|DELAYED|HIGH_PRIORITY|IGNORE)\\s+)*(?:INTO\\s+)?" + tableNamePattern)
+
+// insertTableName returns the table an INSERT statement writes to, without
+// having to parse the whole statement.
+func insertTableName(query string) string {
+	match := insertTablePattern.FindStringSubmatch(query)
+	if match == nil {
+		return ""
+	}
+	return match[1]
+}
+
+// resolveColumnIndex returns the 0-based index of the column referred to by
+// a field name and/or 1-based position. When the table's columns are known
+// the name takes precedence, since positions go stale as soon as a column is
+// added to the table.
+func resolveColumnIndex(field string, position int, columns map[string]int) (int, error) {
+	if columns != nil && field != "" {
+		index, ok := columns[strings.ToLower(field)]
+		if ok {
+			return index, nil
+		}
+		if position <= 0 {
+			return -1, fmt.Errorf("field %s not found in table", field)
+		}
+	}
+
+	if position <= 0 {
+		return -1, fmt.Errorf("field %s has no position and the table's columns are unknown", field)
+	}
+	return position - 1, nil
+}
+
+// columnsFromInsert builds a column name to index map from the explicit column
+// list of an INSERT statement, as written by mysqldump --complete-insert.
+func columnsFromInsert(insertColumns sqlparser.Columns) map[string]int {
+	columns := make(map[string]int, len(insertColumns))
+	for i, column := range insertColumns {
+		columns[column.Lowered()] = i
+	}
+	return columns
+}
+
+// findStalePosition returns an error describing the first field or constraint
+// in the pattern whose configured position doesn't match the column of the
+// same name, as that's a sign the config was written for an older version of
+// the table.
+func findStalePosition(pattern ConfigPattern, columns map[string]int) error {
+	if columns == nil {
+		return nil
+	}
+
+	check := func(field string, position int) error {
+		if position <= 0 || field == "" {
+			// Fields configured only by position have no name to check it against
+			return nil
+		}
+		index, ok := columns[strings.ToLower(field)]
+		if !ok {
+			return fmt.Errorf("field %s is configured with position %d but isn't a column of %s", field, position, pattern.TableName)
+		}
+		if position-1 != index {
+			return fmt.Errorf("field %s is configured with position %d but is column %d of %s", field, position, index+1, pattern.TableName)
+		}
+		return nil
+	}
+
+	for _, constraint := range pattern.DeleteRows {
+		err := constraint.walk(func(nested PatternFieldConstraint) error {
+			return check(nested.Field, nested.Position)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	for _, fieldPattern := range pattern.Fields {
+		if err := check(fieldPattern.Field, fieldPattern.Position); err != nil {
+			return err
+		}
+		for _, constraint := range fieldPattern.Constraints {
+			err := constraint.walk(func(nested PatternFieldConstraint) error {
+				return check(nested.Field, nested.Position)
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"fmt"
+	"github.com/xwb1989/sqlparser"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// scrubType is the field type for free text, in which only the personal data
+// found by the detectors is replaced.
+const scrubType = "scrub"
+
+// scrubDetector finds one kind of personal data in free text, which is then
+// replaced using the given transformation type.
+type scrubDetector struct {
+	pattern        *regexp.Regexp
+	transformation string
+	// accept can reject matches the pattern is too loose to rule out
+	accept func(match string) bool
+}
+
+var (
+	scrubDetectors = map[string]scrubDetector{
+		"email": {
+			pattern:        regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9\-]+(?:\.[A-Za-z0-9\-]+)*\.[A-Za-z]{2,}`),
+			transformation: "email",
+		},
+		"url": {
+			// Trailing punctuation is far more likely to end the sentence than the URL
+			pattern:        regexp.MustCompile(`(?i)\bhttps?://[^\s"'<>]*[^\s"'<>.,;:!?)\]]`),
+			transformation: "url",
+		},
+		"ipv4": {
+			pattern:        regexp.MustCompile(`\b(?:(?:25[0-5]|2[0-4]\d|1\d\d|[1-9]?\d)\.){3}(?:25[0-5]|2[0-4]\d|1\d\d|[1-9]?\d)\b`),
+			transformation: "ipv4",
+		},
+		"phone": {
+			pattern:        regexp.MustCompile(`(?:\+\d{1,3}[ .\-]?)?(?:\(\d{1,5}\)[ .\-]?)?\d{2,5}(?:[ .\-]?\d{2,5}){1,4}`),
+			transformation: "scramble",
+			accept:         looksLikePhoneNumber,
+		},
+	}
+
+	datePattern = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}`)
+
+	// dictionaryTypes are the transformation types whose replacements are
+	// remembered, so the dictionary detector can find the originals in free text
+	dictionaryTypes = map[string]bool{
+		"name":      true,
+		"firstName": true,
+		"lastName":  true,
+		"username":  true,
+		"email":     true,
+	}
+)
+
+// dictionaryDetector finds the names, usernames and emails that have already
+// been replaced elsewhere in the dump, and gives them the same replacement.
+const dictionaryDetector = "dictionary"
+
+// minDictionaryLength leaves out values so short that they'd match all sorts
+// of words, such as the first name "Al".
+const minDictionaryLength = 3
+
+// looksLikePhoneNumber rules out numbers too short to be phone numbers, as well
+// as dates, which the phone pattern would otherwise match.
+func looksLikePhoneNumber(match string) bool {
+	digits := 0
+	for _, char := range match {
+		if char >= '0' && char <= '9' {
+			digits++
+		}
+	}
+	return digits >= 9 && digits <= 15 && !datePattern.MatchString(match)
+}
+
+func validateDetectors(detectors []string) error {
+	for _, detector := range detectors {
+		if _, ok := scrubDetectors[detector]; !ok && detector != dictionaryDetector {
+			return fmt.Errorf("unknown scrub detector %q", detector)
+		}
+	}
+	return nil
+}
+
+// scrubMatch is a piece of personal data found in the text.
+type scrubMatch struct {
+	start, end int
+	detector   string
+	// replacement is already known for matches of the dictionary detector
+	replacement string
+}
+
+// scrub replaces the emails, URLs, IP addresses and phone numbers found in
+// free text, along with the names replaced elsewhere in the dump, leaving the
+// rest of the text, including any HTML, as it was. The detectors option limits
+// which of those are looked for.
+func (p *Pseudonymizer) scrub(fieldPattern PatternField, value *sqlparser.SQLVal) *sqlparser.SQLVal {
+	text := literalString(value)
+
+	detectors := fieldPattern.Options.Detectors
+	if len(detectors) == 0 {
+		for detector := range scrubDetectors {
+			detectors = append(detectors, detector)
+		}
+		detectors = append(detectors, dictionaryDetector)
+	}
+
+	var matches []scrubMatch
+	for _, detector := range detectors {
+		if detector == dictionaryDetector {
+			matches = append(matches, p.dictionaryMatches(text)...)
+			continue
+		}
+		for _, location := range scrubDetectors[detector].pattern.FindAllStringIndex(text, -1) {
+			accept := scrubDetectors[detector].accept
+			if accept != nil && !accept(text[location[0]:location[1]]) {
+				continue
+			}
+			matches = append(matches, scrubMatch{start: location[0], end: location[1], detector: detector})
+		}
+	}
+
+	// Where matches overlap, such as an IP address inside a URL, the one
+	// starting first wins, and the longest one of those. An email found by both
+	// the dictionary and the email detector gets the replacement it already has.
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].start != matches[j].start {
+			return matches[i].start < matches[j].start
+		}
+		if matches[i].end != matches[j].end {
+			return matches[i].end > matches[j].end
+		}
+		if (matches[i].detector == dictionaryDetector) != (matches[j].detector == dictionaryDetector) {
+			return matches[i].detector == dictionaryDetector
+		}
+		return matches[i].detector < matches[j].detector
+	})
+
+	// The same value mentioned twice in the text gets the same replacement
+	replacements := make(map[string]string)
+
+	var result strings.Builder
+	pos := 0
+	for _, match := range matches {
+		if match.start < pos {
+			continue
+		}
+
+		original := text[match.start:match.end]
+		replacement, ok := replacements[original]
+		if match.detector == dictionaryDetector {
+			replacement, ok = match.replacement, true
+		}
+		if !ok {
+			entityPattern := PatternField{
+				Field: fieldPattern.Field,
+				Type:  scrubDetectors[match.detector].transformation,
+			}
+			// Going around transform keeps what scrub finds out of the dictionary,
+			// which only holds the names replaced in fields of their own
+			replacement = literalString(p.replace(entityPattern, sqlparser.NewStrVal([]byte(original))))
+			replacements[original] = replacement
+		}
+
+		result.WriteString(text[pos:match.start])
+		result.WriteString(replacement)
+		pos = match.end
+	}
+	result.WriteString(text[pos:])
+
+	return newValOfType(value, result.String())
+}
+
+// remember adds a replaced name, username or email to the dictionary.
+func (p *Pseudonymizer) remember(original, replacement string) {
+	if len(original) < minDictionaryLength || original == replacement {
+		return
+	}
+	if p.chunk != nil {
+		p.chunk.names[original] = replacement
+		return
+	}
+
+	p.namesMu.Lock()
+	defer p.namesMu.Unlock()
+
+	if _, ok := p.names[original]; !ok {
+		// Longer names come first, so "John Smith" wins over "John"
+		key := dictionaryKey(original)
+		originals := p.nameIndex[key]
+		i := sort.Search(len(originals), func(i int) bool {
+			if len(originals[i]) != len(original) {
+				return len(originals[i]) < len(original)
+			}
+			return originals[i] > original
+		})
+		originals = append(originals, "")
+		copy(originals[i+1:], originals[i:])
+		originals[i] = original
+		p.nameIndex[key] = originals
+	}
+	p.names[original] = replacement
+}
+
+// dictionaryKey returns what a name is found by in the dictionary: its first
+// word, or its first character if it doesn't start with a word.
+func dictionaryKey(name string) string {
+	end := 0
+	for end < len(name) && isWordByte(name[end]) {
+		end++
+	}
+	if end == 0 {
+		end = 1
+	}
+	return name[:end]
+}
+
+// dictionaryMatches finds the mentions of replaced names in the text. Only
+// whole words are matched, so "Ann" doesn't turn up in "Annual".
+func (p *Pseudonymizer) dictionaryMatches(text string) []scrubMatch {
+	p.namesMu.RLock()
+	defer p.namesMu.RUnlock()
+	if len(p.names) == 0 {
+		return nil
+	}
+
+	var matches []scrubMatch
+	for start := 0; start < len(text); {
+		// Names are looked up by the word starting here, or by the character
+		// here if it isn't part of a word
+		end := start + 1
+		if isWordByte(text[start]) {
+			if start > 0 && isWordByte(text[start-1]) {
+				start++
+				continue
+			}
+			for end < len(text) && isWordByte(text[end]) {
+				end++
+			}
+		}
+
+		found := ""
+		for _, original := range p.nameIndex[text[start:end]] {
+			if !strings.HasPrefix(text[start:], original) {
+				continue
+			}
+			last, next := start+len(original)-1, start+len(original)
+			if isWordByte(text[last]) && next < len(text) && isWordByte(text[next]) {
+				continue
+			}
+			found = original
+			break
+		}
+		if found == "" {
+			start = end
+			continue
+		}
+
+		matches = append(matches, scrubMatch{
+			start:       start,
+			end:         start + len(found),
+			detector:    dictionaryDetector,
+			replacement: p.names[found],
+		})
+		start += len(found)
+	}
+	return matches
+}
+
+func isWordByte(char byte) bool {
+	return char == '_' || (char >= '0' && char <= '9') || (char >= 'a' && char <= 'z') || (char >= 'A' && char <= 'Z')
+}
+
+// tablesUsingDictionary returns the tables with free text fields that look for
+// the names replaced elsewhere in the dump.
+func (c Config) tablesUsingDictionary() map[string]bool {
+	tables := make(map[string]bool)
+	for _, pattern := range c.Patterns {
+		if pattern.usesDictionary() {
+			tables[pattern.TableName] = true
+		}
+	}
+	return tables
+}
+
+// usesDictionary reports whether any of the table's fields scrub the names
+// replaced elsewhere out of free text.
+func (p ConfigPattern) usesDictionary() bool {
+	for _, fieldPattern := range p.Fields {
+		if usesDictionary(fieldPattern.Type, fieldPattern.Options) {
+			return true
+		}
+		for _, pathField := range fieldPattern.Paths {
+			if usesDictionary(pathField.Type, pathField.Options) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func usesDictionary(transformation string, options TransformationOptions) bool {
+	if transformation != scrubType {
+		return false
+	}
+	if len(options.Detectors) == 0 {
+		return true
+	}
+	for _, detector := range options.Detectors {
+		if detector == dictionaryDetector {
+			return true
+		}
+	}
+	return false
+}
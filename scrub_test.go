@@ -0,0 +1,136 @@
+package main
+
+import (
+	"github.com/xwb1989/sqlparser"
+	"reflect"
+	"syreclabs.com/go/faker"
+	"testing"
+)
+
+func TestScrub(t *testing.T) {
+
+	var tests = []struct {
+		testName  string
+		detectors []string
+		text      string
+		wants     string
+	}{
+		{
+			testName: "all detectors",
+			text:     `<p>Mail <a href="mailto:jane.doe@client.co.uk">jane.doe@client.co.uk</a> or call +44 (0)20 7946 0958.</p> Logged from 203.0.113.7 on 2019-06-12 00:59:19, see https://www.client.com/orders/?id=5.`,
+			wants:    `<p>Mail <a href="mailto:aniya.jast@example.net">aniya.jast@example.net</a> or call +40 (8)48 2117 2321.</p> Logged from 23.213.243.62 on 2019-06-12 00:59:19, see http://hudson.net/kelli.`,
+		},
+		{
+			testName:  "some detectors",
+			detectors: []string{"email"},
+			text:      `Ping jane@client.com from 203.0.113.7`,
+			wants:     `Ping aniya.jast@example.net from 203.0.113.7`,
+		},
+		{
+			testName: "nothing to scrub",
+			text:     "Hi, this is a comment.\nOrder #12345 shipped.",
+			wants:    "Hi, this is a comment.\nOrder #12345 shipped.",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.testName, func(t *testing.T) {
+			faker.Seed(432)
+
+			fieldPattern := PatternField{
+				Field:   "comment_content",
+				Type:    "scrub",
+				Options: TransformationOptions{Detectors: test.detectors},
+			}
+
+			result := literalString(newPseudonymizer(nil).transform(fieldPattern, sqlparser.NewStrVal([]byte(test.text))))
+			if result != test.wants {
+				t.Error("\nExpected:\n", test.wants, "\nActual:\n", result)
+			}
+		})
+	}
+}
+
+func TestScrubDictionary(t *testing.T) {
+	faker.Seed(432)
+
+	config := Config{
+		Patterns: []ConfigPattern{
+			{
+				TableName: "wp_users",
+				Fields: []PatternField{
+					{Field: "display_name", Type: "name"},
+					{Field: "user_email", Type: "email"},
+				},
+			},
+			{
+				TableName: "wp_comments",
+				Fields: []PatternField{
+					{Field: "comment_content", Type: "scrub", Options: TransformationOptions{Detectors: []string{"dictionary"}}},
+				},
+			},
+		},
+	}
+	query := "INSERT INTO `wp_users` (`ID`, `display_name`, `user_email`) VALUES (1,'John Smith','john@client.com'),(2,'Al','al@client.com');\n" +
+		"INSERT INTO `wp_comments` (`comment_ID`, `comment_content`) VALUES (1,'Thanks John Smith! Mail john@client.com, not Johnny or Al.');\n"
+	wants := "insert into wp_users(ID, display_name, user_email) values (1, 'Elinor Will', 'eula.jones@example.net'), (2, 'Miss Alan Green', 'rahsaan_nolan@example.com');\n" +
+		"insert into wp_comments(comment_ID, comment_content) values (1, 'Thanks Elinor Will! Mail eula.jones@example.net, not Johnny or Al.');\n"
+
+	result := processString(t, config, query)
+
+	if result != wants {
+		t.Error("\nExpected:\n", wants, "\nActual:\n", result)
+	}
+}
+
+func TestDictionaryMatches(t *testing.T) {
+	p := newPseudonymizer(nil)
+	p.remember("John", "Pablo")
+	p.remember("John Smith", "Nora Raynor")
+	p.remember("Ann", "Kylie")
+	p.remember("john@client.com", "pablo@example.net")
+	p.remember("[admin]", "[editor]")
+	p.remember("John Doe", "Ida Lowe")
+
+	// Longer names come first, whatever order they were remembered in
+	if index, wants := p.nameIndex["John"], []string{"John Smith", "John Doe", "John"}; !reflect.DeepEqual(index, wants) {
+		t.Errorf("Expected the names starting with John to be %q, got %q", wants, index)
+	}
+
+	var tests = []struct {
+		text  string
+		wants []string
+	}{
+		{text: "John Smith and John", wants: []string{"Nora Raynor", "Pablo"}},
+		{text: "Johnny, Annual, Ann.", wants: []string{"Kylie"}},
+		{text: "Mail john@client.com.", wants: []string{"pablo@example.net"}},
+		{text: "Posted by [admin]", wants: []string{"[editor]"}},
+		{text: "Nobody", wants: nil},
+	}
+
+	for _, test := range tests {
+		var replacements []string
+		for _, match := range p.dictionaryMatches(test.text) {
+			replacements = append(replacements, match.replacement)
+		}
+		if !reflect.DeepEqual(replacements, test.wants) {
+			t.Errorf("%q: expected %q, got %q", test.text, test.wants, replacements)
+		}
+	}
+}
+
+func TestRememberOnlyForDictionary(t *testing.T) {
+	fieldPattern := PatternField{Field: "display_name", Type: "name"}
+
+	p := newPseudonymizer(nil)
+	p.transform(fieldPattern, sqlparser.NewStrVal([]byte("John Smith")))
+	if len(p.names) != 0 {
+		t.Error("Expected no names to be remembered without the dictionary, got", p.names)
+	}
+
+	p.dictionary = true
+	p.transform(fieldPattern, sqlparser.NewStrVal([]byte("John Smith")))
+	if _, ok := p.names["John Smith"]; !ok {
+		t.Error("Expected the name to be remembered for the dictionary")
+	}
+}
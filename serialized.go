@@ -0,0 +1,339 @@
+package main
+
+import (
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"github.com/xwb1989/sqlparser"
+	"strconv"
+	"strings"
+)
+
+// serializedType is the field type for PHP-serialized values, whose paths are
+// transformed individually.
+const serializedType = "serialized"
+
+// PathField is a transformation applied to a single value inside a structured
+// value, such as a PHP-serialized array.
+type PathField struct {
+	Path           string                `json:"path"`
+	Type           string                `json:"type"`
+	Options        TransformationOptions `json:"options"`
+	ConsistencyKey string                `json:"consistencyKey"`
+}
+
+// patternField returns the PatternField used to transform the values found at
+// the path, so they're transformed like any other field.
+func (f PathField) patternField(parent PatternField) PatternField {
+	return PatternField{
+		Field:          parent.Field + "." + f.Path,
+		Type:           f.Type,
+		Options:        f.Options,
+		ConsistencyKey: f.ConsistencyKey,
+	}
+}
+
+// phpValue is a value parsed from PHP's serialize() format.
+type phpValue struct {
+	// kind is the letter PHP uses for the value's type, e.g. s for strings
+	// and a for arrays.
+	kind byte
+	// raw holds the contents of strings, the text of numbers and booleans, and
+	// the serialized data of classes implementing Serializable.
+	raw string
+	// className is the class of objects.
+	className string
+	// entries are the keys and values of arrays and objects.
+	entries []phpEntry
+}
+
+type phpEntry struct {
+	key   *phpValue
+	value *phpValue
+}
+
+// name returns the key as it would be referred to in a path. Private and
+// protected object properties are prefixed with a NUL delimited class name or
+// asterisk, which is dropped.
+func (e phpEntry) name() string {
+	if strings.HasPrefix(e.key.raw, "\x00") {
+		if end := strings.Index(e.key.raw[1:], "\x00"); end != -1 {
+			return e.key.raw[end+2:]
+		}
+	}
+	return e.key.raw
+}
+
+type phpParser struct {
+	data string
+	pos  int
+}
+
+// unserializePHP parses a value written by PHP's serialize().
+func unserializePHP(data string) (*phpValue, error) {
+	parser := &phpParser{data: data}
+	value, err := parser.parseValue()
+	if err != nil {
+		return nil, err
+	}
+	if parser.pos != len(data) {
+		return nil, fmt.Errorf("unexpected data after serialized value at byte %d", parser.pos)
+	}
+	return value, nil
+}
+
+func (p *phpParser) parseValue() (*phpValue, error) {
+	if p.pos >= len(p.data) {
+		return nil, fmt.Errorf("unexpected end of serialized value")
+	}
+
+	value := &phpValue{kind: p.data[p.pos]}
+	p.pos++
+
+	switch value.kind {
+	case 'N':
+		return value, p.expect(";")
+
+	case 'b', 'i', 'd', 'r', 'R':
+		if err := p.expect(":"); err != nil {
+			return nil, err
+		}
+		raw, err := p.readUntil(';')
+		value.raw = raw
+		return value, err
+
+	case 's', 'E':
+		if err := p.expect(":"); err != nil {
+			return nil, err
+		}
+		raw, err := p.readString()
+		if err != nil {
+			return nil, err
+		}
+		value.raw = raw
+		return value, p.expect(";")
+
+	case 'a':
+		if err := p.expect(":"); err != nil {
+			return nil, err
+		}
+		return value, p.readEntries(value)
+
+	case 'O', 'C':
+		if err := p.expect(":"); err != nil {
+			return nil, err
+		}
+		className, err := p.readString()
+		if err != nil {
+			return nil, err
+		}
+		value.className = className
+		if err := p.expect(":"); err != nil {
+			return nil, err
+		}
+
+		if value.kind == 'O' {
+			return value, p.readEntries(value)
+		}
+
+		// Classes implementing Serializable write whatever they like, so all we
+		// can do is keep it as it is
+		length, err := p.readLength(':')
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("{"); err != nil {
+			return nil, err
+		}
+		raw, err := p.readBytes(length)
+		if err != nil {
+			return nil, err
+		}
+		value.raw = raw
+		return value, p.expect("}")
+	}
+
+	return nil, fmt.Errorf("unknown serialized type %q at byte %d", value.kind, p.pos-1)
+}
+
+// readEntries reads the `count:{key;value;...}` part of arrays and objects.
+func (p *phpParser) readEntries(value *phpValue) error {
+	count, err := p.readLength(':')
+	if err != nil {
+		return err
+	}
+	if err := p.expect("{"); err != nil {
+		return err
+	}
+
+	value.entries = make([]phpEntry, 0, count)
+	for i := 0; i < count; i++ {
+		key, err := p.parseValue()
+		if err != nil {
+			return err
+		}
+		if key.kind != 'i' && key.kind != 's' {
+			return fmt.Errorf("invalid serialized array key type %q", key.kind)
+		}
+		entry, err := p.parseValue()
+		if err != nil {
+			return err
+		}
+		value.entries = append(value.entries, phpEntry{key: key, value: entry})
+	}
+	return p.expect("}")
+}
+
+// readString reads the `length:"contents"` part of strings and class names.
+func (p *phpParser) readString() (string, error) {
+	length, err := p.readLength(':')
+	if err != nil {
+		return "", err
+	}
+	if err := p.expect(`"`); err != nil {
+		return "", err
+	}
+	raw, err := p.readBytes(length)
+	if err != nil {
+		return "", err
+	}
+	return raw, p.expect(`"`)
+}
+
+func (p *phpParser) readLength(terminator byte) (int, error) {
+	raw, err := p.readUntil(terminator)
+	if err != nil {
+		return 0, err
+	}
+	length, err := strconv.Atoi(raw)
+	if err != nil || length < 0 {
+		return 0, fmt.Errorf("invalid serialized length %q", raw)
+	}
+	return length, nil
+}
+
+func (p *phpParser) readUntil(terminator byte) (string, error) {
+	end := strings.IndexByte(p.data[p.pos:], terminator)
+	if end == -1 {
+		return "", fmt.Errorf("missing %q after byte %d", terminator, p.pos)
+	}
+	raw := p.data[p.pos : p.pos+end]
+	p.pos += end + 1
+	return raw, nil
+}
+
+func (p *phpParser) readBytes(length int) (string, error) {
+	if p.pos+length > len(p.data) {
+		return "", fmt.Errorf("serialized string of %d bytes at byte %d runs past the end of the value", length, p.pos)
+	}
+	raw := p.data[p.pos : p.pos+length]
+	p.pos += length
+	return raw, nil
+}
+
+func (p *phpParser) expect(expected string) error {
+	if !strings.HasPrefix(p.data[p.pos:], expected) {
+		return fmt.Errorf("expected %q at byte %d", expected, p.pos)
+	}
+	p.pos += len(expected)
+	return nil
+}
+
+// serialize writes the value in PHP's serialize() format, with the byte
+// lengths of strings worked out afresh.
+func (v *phpValue) serialize() string {
+	var buf strings.Builder
+	v.writeTo(&buf)
+	return buf.String()
+}
+
+func (v *phpValue) writeTo(buf *strings.Builder) {
+	switch v.kind {
+	case 'N':
+		buf.WriteString("N;")
+	case 'b', 'i', 'd', 'r', 'R':
+		fmt.Fprintf(buf, "%c:%s;", v.kind, v.raw)
+	case 's', 'E':
+		fmt.Fprintf(buf, "%c:%d:\"%s\";", v.kind, len(v.raw), v.raw)
+	case 'a':
+		fmt.Fprintf(buf, "a:%d:{", len(v.entries))
+		v.writeEntriesTo(buf)
+	case 'O':
+		fmt.Fprintf(buf, "O:%d:\"%s\":%d:{", len(v.className), v.className, len(v.entries))
+		v.writeEntriesTo(buf)
+	case 'C':
+		fmt.Fprintf(buf, "C:%d:\"%s\":%d:{%s}", len(v.className), v.className, len(v.raw), v.raw)
+	}
+}
+
+func (v *phpValue) writeEntriesTo(buf *strings.Builder) {
+	for _, entry := range v.entries {
+		entry.key.writeTo(buf)
+		entry.value.writeTo(buf)
+	}
+	buf.WriteString("}")
+}
+
+// transformSerialized applies the field's path transformations to the values
+// inside a PHP-serialized value.
+func (p *Pseudonymizer) transformSerialized(fieldPattern PatternField, value *sqlparser.SQLVal) *sqlparser.SQLVal {
+	parsed, err := unserializePHP(literalString(value))
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err,
+			"field": fieldPattern.Field,
+		}).Warn("Skipping transformation of value that isn't PHP-serialized")
+		return value
+	}
+
+	for _, pathField := range fieldPattern.Paths {
+		p.transformSerializedPath(parsed, strings.Split(pathField.Path, "."), pathField.patternField(fieldPattern))
+	}
+
+	return newValOfType(value, parsed.serialize())
+}
+
+// transformSerializedPath walks down the path, where * matches every key, and
+// transforms the strings and numbers found at the end of it.
+func (p *Pseudonymizer) transformSerializedPath(value *phpValue, path []string, fieldPattern PatternField) {
+	if len(path) == 0 {
+		p.transformSerializedScalar(value, fieldPattern)
+		return
+	}
+
+	for _, entry := range value.entries {
+		if path[0] == "*" || entry.name() == path[0] {
+			p.transformSerializedPath(entry.value, path[1:], fieldPattern)
+		}
+	}
+}
+
+func (p *Pseudonymizer) transformSerializedScalar(value *phpValue, fieldPattern PatternField) {
+	var original *sqlparser.SQLVal
+	switch value.kind {
+	case 's':
+		original = sqlparser.NewStrVal([]byte(value.raw))
+	case 'i':
+		original = sqlparser.NewIntVal([]byte(value.raw))
+	case 'd':
+		original = sqlparser.NewFloatVal([]byte(value.raw))
+	default:
+		// Nulls, booleans, arrays and objects are left as they are
+		return
+	}
+
+	// Like values in columns, empty strings are left empty
+	if len(original.Val) == 0 {
+		return
+	}
+
+	replacement := p.transform(fieldPattern, original)
+
+	// Numbers stay numbers as long as the transformation kept them that way
+	switch {
+	case value.kind == 'i' && replacement.Type == sqlparser.IntVal:
+	case value.kind == 'd' && (replacement.Type == sqlparser.FloatVal || replacement.Type == sqlparser.IntVal):
+	default:
+		value.kind = 's'
+	}
+	value.raw = literalString(replacement)
+}
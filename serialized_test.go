@@ -0,0 +1,97 @@
+package main
+
+import (
+	"github.com/xwb1989/sqlparser"
+	"syreclabs.com/go/faker"
+	"testing"
+)
+
+func TestUnserializePHPRoundTrip(t *testing.T) {
+	for _, serialized := range []string{
+		`N;`,
+		`b:1;`,
+		`i:-42;`,
+		`d:0.5;`,
+		`s:6:"héllo";`,
+		`s:10:"say "hi";!";`,
+		`a:0:{}`,
+		`a:2:{i:0;s:3:"foo";s:3:"bar";a:1:{s:3:"baz";N;}}`,
+		"O:8:\"stdClass\":2:{s:4:\"name\";s:4:\"John\";s:10:\"\x00*\x00private\";b:0;}",
+		`C:11:"ArrayObject":21:{x:i:0;a:0:{};m:a:0:{}}`,
+		`a:2:{i:0;O:8:"stdClass":0:{}i:1;r:2;}`,
+	} {
+		parsed, err := unserializePHP(serialized)
+		if err != nil {
+			t.Errorf("Failed parsing %q: %s", serialized, err)
+			continue
+		}
+
+		if result := parsed.serialize(); result != serialized {
+			t.Error("\nExpected:\n", serialized, "\nActual:\n", result)
+		}
+	}
+}
+
+func TestUnserializePHPInvalid(t *testing.T) {
+	for _, serialized := range []string{
+		``,
+		`Lorum ipsum.`,
+		`s:10:"short";`,
+		`a:2:{i:0;s:3:"foo";}`,
+		`i:1;trailing`,
+	} {
+		if _, err := unserializePHP(serialized); err == nil {
+			t.Errorf("Expected an error parsing %q", serialized)
+		}
+	}
+}
+
+func TestTransformSerialized(t *testing.T) {
+	faker.Seed(432)
+
+	fieldPattern := PatternField{
+		Field: "meta_value",
+		Type:  "serialized",
+		Paths: []PathField{
+			{Path: "billing.email", Type: "email"},
+			{Path: "addresses.*.phone", Type: "mask", Options: TransformationOptions{KeepLast: 2}},
+			{Path: "customer.name", Type: "name"},
+		},
+	}
+	value := sqlparser.NewStrVal([]byte(`a:3:{s:7:"billing";a:2:{s:5:"email";s:21:"hosting@humanmade.com";s:7:"country";s:2:"GB";}s:9:"addresses";a:2:{i:0;a:1:{s:5:"phone";s:6:"123456";}i:1;a:1:{s:5:"phone";i:654321;}}s:8:"customer";O:8:"Customer":1:{s:7:"` + "\x00*\x00" + `name";s:6:"Zoë B";}}`))
+	wants := `'a:3:{s:7:\"billing\";a:2:{s:5:\"email\";s:22:\"aniya.jast@example.net\";s:7:\"country\";s:2:\"GB\";}s:9:\"addresses\";a:2:{i:0;a:1:{s:5:\"phone\";s:6:\"****56\";}i:1;a:1:{s:5:\"phone\";s:6:\"****21\";}}s:8:\"customer\";O:8:\"Customer\":1:{s:7:\"\0*\0name\";s:14:\"Mr. Cale Swift\";}}'`
+
+	result := sqlparser.String(newPseudonymizer(nil).transform(fieldPattern, value))
+	if result != wants {
+		t.Error("\nExpected:\n", wants, "\nActual:\n", result)
+	}
+}
+
+func TestSerializedInsert(t *testing.T) {
+	faker.Seed(432)
+
+	config := Config{
+		Patterns: []ConfigPattern{
+			{
+				TableName: "wp_usermeta",
+				Fields: []PatternField{
+					{
+						Field: "meta_value",
+						Type:  "serialized",
+						Paths: []PathField{
+							{Path: "email", Type: "email"},
+						},
+					},
+				},
+			},
+		},
+	}
+	query := "INSERT INTO `wp_usermeta` (`umeta_id`, `meta_value`) VALUES (1,'a:2:{s:5:\\\"email\\\";s:21:\\\"hosting@humanmade.com\\\";s:4:\\\"note\\\";s:9:\\\"it\\'s \\\"ok\\\"\\\";}'),(2,'not serialized');\n"
+	wants := "insert into wp_usermeta(umeta_id, meta_value) values (1, 'a:2:{s:5:\\\"email\\\";s:18:\\\"elinor@example.org\\\";s:4:\\\"note\\\";s:9:\\\"it\\'s \\\"ok\\\"\\\";}'), (2, 'not serialized');\n"
+
+	result := processString(t, config, query)
+
+	if result != wants {
+		t.Error("\nExpected:\n", wants, "\nActual:\n", result)
+	}
+}
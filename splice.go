@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bytes"
+	"github.com/xwb1989/sqlparser"
+	"strings"
+)
+
+// insertSnapshot remembers the rows of an INSERT statement as they were
+// parsed, so that only the values changed since have to be written into the
+// statement's original text.
+type insertSnapshot struct {
+	rows []sqlparser.ValTuple
+	// onDup is what the ON DUPLICATE KEY UPDATE clause assigns, if there is one
+	onDup sqlparser.ValTuple
+	// positions finds the original position of a row that's still around, by
+	// the address of its first value, as rows are dropped and modified in place
+	positions map[*sqlparser.Expr]int
+}
+
+func snapshotInsert(stmt sqlparser.Statement) *insertSnapshot {
+	insert, ok := stmt.(*sqlparser.Insert)
+	if !ok {
+		return nil
+	}
+	values, ok := insert.Rows.(sqlparser.Values)
+	if !ok {
+		return nil
+	}
+
+	snapshot := &insertSnapshot{
+		rows:      make([]sqlparser.ValTuple, len(values)),
+		positions: make(map[*sqlparser.Expr]int, len(values)),
+	}
+	for i, row := range values {
+		snapshot.rows[i] = make(sqlparser.ValTuple, len(row))
+		for j, expr := range row {
+			snapshot.rows[i][j] = copyLiteral(expr)
+		}
+		if len(row) > 0 {
+			snapshot.positions[&row[0]] = i
+		}
+	}
+	for _, update := range insert.OnDup {
+		snapshot.onDup = append(snapshot.onDup, copyLiteral(update.Expr))
+	}
+	return snapshot
+}
+
+// copyLiteral copies literals, in case a transformation changes them in place.
+func copyLiteral(expr sqlparser.Expr) sqlparser.Expr {
+	if value, ok := expr.(*sqlparser.SQLVal); ok {
+		valueCopy := *value
+		return &valueCopy
+	}
+	return expr
+}
+
+// splice writes the changed values of the statement into its original text,
+// leaving the formatting, identifier quoting, comments and untouched values
+// exactly as they were. Rows that have been dropped are cut out along with the
+// comma before them. It returns false if the statement can't be spliced, in
+// which case it has to be recompiled instead.
+func (s *insertSnapshot) splice(original string, stmt sqlparser.Statement) (string, bool) {
+	insert, ok := stmt.(*sqlparser.Insert)
+	if !ok {
+		return "", false
+	}
+	values, ok := insert.Rows.(sqlparser.Values)
+	if !ok || len(values) == 0 {
+		return "", false
+	}
+	if len(insert.OnDup) != len(s.onDup) {
+		return "", false
+	}
+	for i, update := range insert.OnDup {
+		if valueChanged(s.onDup[i], update.Expr) {
+			// Only the rows are spliced, so let the clause be recompiled
+			return "", false
+		}
+	}
+	tuples, ok := findTuples(original)
+	if !ok || len(tuples) != len(s.rows) {
+		return "", false
+	}
+
+	var result strings.Builder
+	result.WriteString(original[:tuples[0].start])
+
+	previous := -1
+	for _, row := range values {
+		if len(row) == 0 {
+			return "", false
+		}
+		i, ok := s.positions[&row[0]]
+		if !ok || i <= previous || len(row) != len(s.rows[i]) || len(row) != len(tuples[i].values) {
+			return "", false
+		}
+
+		if previous >= 0 {
+			result.WriteString(original[tuples[i-1].end:tuples[i].start])
+		}
+
+		pos := tuples[i].start
+		for j, expr := range row {
+			if !valueChanged(s.rows[i][j], expr) {
+				continue
+			}
+			span := tuples[i].values[j]
+			result.WriteString(original[pos:span.start])
+			result.WriteString(sqlparser.String(expr))
+			pos = span.end
+		}
+		result.WriteString(original[pos:tuples[i].end])
+
+		previous = i
+	}
+
+	result.WriteString(original[tuples[len(tuples)-1].end:])
+	return result.String(), true
+}
+
+// valueChanged reports whether a value was replaced. Transformations only ever
+// replace values with literals, and a literal replaced by the same literal
+// keeps its original text.
+func valueChanged(before, after sqlparser.Expr) bool {
+	beforeValue, beforeIsLiteral := before.(*sqlparser.SQLVal)
+	afterValue, afterIsLiteral := after.(*sqlparser.SQLVal)
+	if !afterIsLiteral {
+		return false
+	}
+	return !beforeIsLiteral || beforeValue.Type != afterValue.Type || !bytes.Equal(beforeValue.Val, afterValue.Val)
+}
+
+// textSpan is the position of a piece of a statement's text.
+type textSpan struct {
+	start, end int
+}
+
+// tupleSpan is the position of a row of values, from its opening parenthesis
+// up to and including its closing one, along with each of its values.
+type tupleSpan struct {
+	textSpan
+	values []textSpan
+}
+
+// findTuples returns the positions of the rows following an INSERT
+// statement's VALUES keyword.
+func findTuples(statement string) ([]tupleSpan, bool) {
+	cursor := &sqlCursor{text: statement}
+	if !cursor.skipToKeyword("VALUES", "VALUE") {
+		return nil, false
+	}
+
+	var tuples []tupleSpan
+	for {
+		cursor.skipSpace()
+		tuple, ok := cursor.readTuple()
+		if !ok {
+			return nil, false
+		}
+		tuples = append(tuples, tuple)
+
+		cursor.skipSpace()
+		if !cursor.consume(',') {
+			return tuples, true
+		}
+	}
+}
+
+// sqlCursor walks through the text of a single statement.
+type sqlCursor struct {
+	text string
+	pos  int
+}
+
+func (c *sqlCursor) done() bool {
+	return c.pos >= len(c.text)
+}
+
+func (c *sqlCursor) consume(char byte) bool {
+	if c.done() || c.text[c.pos] != char {
+		return false
+	}
+	c.pos++
+	return true
+}
+
+// skipSpace skips whitespace and comments.
+func (c *sqlCursor) skipSpace() {
+	for !c.done() {
+		switch rest := c.text[c.pos:]; {
+		case strings.IndexByte(" \t\r\n", rest[0]) != -1:
+			c.pos++
+		case rest[0] == '#' || isDashComment(rest):
+			end := strings.IndexByte(rest, '\n')
+			if end == -1 {
+				end = len(rest) - 1
+			}
+			c.pos += end + 1
+		case strings.HasPrefix(rest, "/*"):
+			end := strings.Index(rest[2:], "*/")
+			if end == -1 {
+				c.pos = len(c.text)
+			} else {
+				c.pos += end + 4
+			}
+		default:
+			return
+		}
+	}
+}
+
+// skipQuoted skips past a quoted string or identifier starting at the cursor.
+func (c *sqlCursor) skipQuoted() {
+	quote := c.text[c.pos]
+	c.pos++
+	for !c.done() {
+		char := c.text[c.pos]
+		switch {
+		case char == '\\' && quote != '`':
+			c.pos += 2
+		case char == quote && c.pos+1 < len(c.text) && c.text[c.pos+1] == quote:
+			c.pos += 2
+		case char == quote:
+			c.pos++
+			return
+		default:
+			c.pos++
+		}
+	}
+}
+
+func isWordChar(char byte) bool {
+	return isWordByte(char) || char == '$'
+}
+
+// skipToKeyword moves the cursor past the first of the keywords found outside
+// of strings, identifiers and comments, stopping at the end of the statement.
+func (c *sqlCursor) skipToKeyword(keywords ...string) bool {
+	for !c.done() {
+		c.skipSpace()
+		if c.done() {
+			return false
+		}
+
+		char := c.text[c.pos]
+		switch {
+		case char == ';':
+			return false
+		case char == '\'' || char == '"' || char == '`':
+			c.skipQuoted()
+		case isWordChar(char):
+			start := c.pos
+			for !c.done() && isWordChar(c.text[c.pos]) {
+				c.pos++
+			}
+			for _, keyword := range keywords {
+				if strings.EqualFold(c.text[start:c.pos], keyword) {
+					return true
+				}
+			}
+		default:
+			c.pos++
+		}
+	}
+	return false
+}
+
+// readTuple reads a parenthesised row of values, splitting them on the commas
+// that aren't nested inside function calls or the like.
+func (c *sqlCursor) readTuple() (tupleSpan, bool) {
+	tuple := tupleSpan{textSpan: textSpan{start: c.pos}}
+	if !c.consume('(') {
+		return tuple, false
+	}
+
+	c.skipSpace()
+	if c.consume(')') {
+		tuple.end = c.pos
+		return tuple, true
+	}
+
+	depth := 0
+	value := textSpan{start: c.pos}
+	for !c.done() {
+		char := c.text[c.pos]
+		switch {
+		case char == '\'' || char == '"' || char == '`':
+			c.skipQuoted()
+			value.end = c.pos
+			continue
+		case char == '#' || isDashComment(c.text[c.pos:]) || strings.HasPrefix(c.text[c.pos:], "/*"):
+			c.skipSpace()
+			continue
+		case strings.IndexByte(" \t\r\n", char) != -1:
+			c.pos++
+			continue
+		case char == '(':
+			depth++
+		case char == ')' && depth > 0:
+			depth--
+		case char == ',' && depth == 0, char == ')':
+			tuple.values = append(tuple.values, value)
+			c.pos++
+			if char == ')' {
+				tuple.end = c.pos
+				return tuple, true
+			}
+			c.skipSpace()
+			value = textSpan{start: c.pos}
+			continue
+		}
+		c.pos++
+		value.end = c.pos
+	}
+	return tuple, false
+}
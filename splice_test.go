@@ -0,0 +1,69 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestPreserveFormatting(t *testing.T) {
+
+	config := Config{
+		Patterns: []ConfigPattern{
+			{
+				TableName: "wp_options",
+				DeleteRows: []PatternFieldConstraint{
+					{Field: "option_name", Operator: "like", Value: "\\_transient\\_%"},
+				},
+			},
+		},
+		Replacements: []Replacement{
+			{Search: "https://www.client.com", Replace: "https://example.com"},
+		},
+		PreserveFormatting: true,
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		wants string
+	}{
+		{
+			name:  "untouched",
+			query: "INSERT INTO `wp_posts` VALUES (1,'Hello world!',NULL,0x00FF);\n",
+			wants: "INSERT INTO `wp_posts` VALUES (1,'Hello world!',NULL,0x00FF);\n",
+		},
+		{
+			name:  "changed value",
+			query: "INSERT INTO `wp_posts` VALUES (1,'Hello world!'),(2 , 'https://www.client.com' );\n",
+			wants: "INSERT INTO `wp_posts` VALUES (1,'Hello world!'),(2 , 'https://example.com' );\n",
+		},
+		{
+			name: "deleted rows",
+			query: "INSERT INTO `wp_options` (`option_id`, `option_name`, `option_value`) VALUES (1,'_transient_doing_cron','1564555155'),\n" +
+				"(2,'siteurl','https://www.client.com'),\n" +
+				"(3,'_transient_foo','bar'),\n" +
+				"(4,'blogname','Client');\n",
+			wants: "INSERT INTO `wp_options` (`option_id`, `option_name`, `option_value`) VALUES (2,'siteurl','https://example.com'),\n" +
+				"(4,'blogname','Client');\n",
+		},
+		{
+			name:  "expressions",
+			query: "INSERT INTO `wp_posts` VALUES (1,CONCAT('a,', 'b)'),'https://www.client.com',/* note */ 2);\n",
+			wants: "INSERT INTO `wp_posts` VALUES (1,CONCAT('a,', 'b)'),'https://example.com',/* note */ 2);\n",
+		},
+		{
+			name:  "changed assignment",
+			query: "INSERT INTO `wp_options` VALUES (5,'home','https://www.client.com') ON DUPLICATE KEY UPDATE `option_value` = 'https://www.client.com';\n",
+			wants: "insert into wp_options values (5, 'home', 'https://example.com') on duplicate key update option_value = 'https://example.com';\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result := processString(t, config, test.query)
+
+			if result != test.wants {
+				t.Error("\nExpected:\n", test.wants, "\nActual:\n", result)
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// streamBatchSize is roughly how much of an INSERT statement's rows are
+// processed at once when streaming rows, unless the scanner says otherwise.
+const streamBatchSize = 256 * 1024
+
+// insertHeader returns the start of an INSERT statement up to its first row,
+// or an empty string if the text doesn't start with an INSERT statement whose
+// rows follow a VALUES keyword.
+func insertHeader(text string) string {
+	if insertTableName(text) == "" {
+		return ""
+	}
+	cursor := &sqlCursor{text: text}
+	if !cursor.skipToKeyword("VALUES", "VALUE") {
+		return ""
+	}
+	cursor.skipSpace()
+	if cursor.done() || cursor.text[cursor.pos] != '(' {
+		return ""
+	}
+	return text[:cursor.pos]
+}
+
+// streamLookahead is how many batches of an INSERT statement's rows are read
+// ahead of being processed, so that anything following the rows can be added
+// to each batch.
+const streamLookahead = 32
+
+// batches reads the rows of the INSERT statement whose header was just
+// returned by next, and calls process with each batch of them as an INSERT
+// statement of its own, so that a statement of any size can be processed a bit
+// at a time. Anything following the rows, such as an ON DUPLICATE KEY UPDATE
+// clause, is added to every batch, which means holding back the batches until
+// the end of the statement. That fails for statements with such a clause that
+// are too big to hold back.
+func (s *sqlScanner) batches(header string, process func(string) error) error {
+	var pending []string
+	processed := false
+	for more := true; more; {
+		var rows, rest string
+		rows, rest, more = s.nextBatch(header)
+		pending = append(pending, rows)
+		if more && len(pending) > s.lookahead {
+			if err := process(pending[0] + s.delimiter + "\n"); err != nil {
+				return err
+			}
+			pending = pending[1:]
+			processed = true
+		}
+		if more {
+			continue
+		}
+
+		if processed && strings.TrimSuffix(strings.TrimSpace(rest), s.delimiter) != "" {
+			return fmt.Errorf("INSERT INTO `%s` is too big to add the clause following its rows to every batch, process it without streaming rows", insertTableName(header))
+		}
+		for _, batch := range pending {
+			if err := process(batch + rest); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// nextBatch reads the next rows of the INSERT statement whose header was just
+// returned by next, returning them after the header. Once the statement's
+// last rows have been read, it returns false along with the rest of the
+// statement, such as an ON DUPLICATE KEY UPDATE clause and the delimiter.
+func (s *sqlScanner) nextBatch(header string) (string, string, bool) {
+	var batch strings.Builder
+	batch.WriteString(header)
+
+	for {
+		batch.WriteString(s.scan(true))
+
+		// Find out whether another row follows
+		separator, more := s.scanSeparator()
+		if !more {
+			return batch.String(), separator + s.scan(false), false
+		}
+
+		if batch.Len() >= s.batchSize {
+			return batch.String(), "", true
+		}
+		batch.WriteString(separator)
+	}
+}
+
+// scanSeparator reads the whitespace, comments and comma between two rows,
+// returning false if there's no comma as the rows have come to an end.
+func (s *sqlScanner) scanSeparator() (string, bool) {
+	var separator strings.Builder
+	comma := false
+	for s.fill() {
+		cursor := &sqlCursor{text: s.line}
+		cursor.skipSpace()
+		if !comma && cursor.consume(',') {
+			comma = true
+			cursor.skipSpace()
+		}
+		separator.WriteString(s.line[:cursor.pos])
+		s.line = s.line[cursor.pos:]
+		if s.line != "" {
+			break
+		}
+	}
+	return separator.String(), comma
+}
+
+// skipRows skips the rows following the chunk when it's an INSERT statement's
+// header.
+func (s *sqlScanner) skipRows(chunk sqlChunk) {
+	if chunk.kind != chunkInsertHeader {
+		return
+	}
+	for more := true; more; {
+		_, _, more = s.nextBatch(chunk.text)
+	}
+}
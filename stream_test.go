@@ -0,0 +1,111 @@
+package main
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNextBatch(t *testing.T) {
+
+	dump := "INSERT INTO `wp_posts` VALUES (1,'a),(b;'),\n" +
+		"(2,'it\\'s'),(3,CONCAT('c', 'd')) , (4,'e');\n" +
+		"INSERT INTO `wp_posts` (`ID`) VALUES (5),(6),(7),(8),(9),(10),(11),(12),(13) ON DUPLICATE KEY UPDATE `ID` = `ID` + 100;\n" +
+		"INSERT INTO `wp_posts` SET `ID` = 14;\n"
+	wants := []string{
+		"header:INSERT INTO `wp_posts` VALUES ",
+		"INSERT INTO `wp_posts` VALUES (1,'a),(b;'),\n(2,'it\\'s'),(3,CONCAT('c', 'd'));\n",
+		"INSERT INTO `wp_posts` VALUES (4,'e');\n",
+		"header:INSERT INTO `wp_posts` (`ID`) VALUES ",
+		// The clause following the rows ends up on every batch
+		"INSERT INTO `wp_posts` (`ID`) VALUES (5),(6),(7),(8),(9),(10) ON DUPLICATE KEY UPDATE `ID` = `ID` + 100;\n",
+		"INSERT INTO `wp_posts` (`ID`) VALUES (11),(12),(13) ON DUPLICATE KEY UPDATE `ID` = `ID` + 100;\n",
+		"INSERT INTO `wp_posts` SET `ID` = 14;\n",
+	}
+
+	// A small buffer makes sure rows are read properly across several reads
+	scanner := newSQLScannerSize(strings.NewReader(dump), 40)
+	scanner.streamRows = true
+	scanner.batchSize = 60
+
+	var results []string
+	for {
+		chunk, err := scanner.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if chunk.kind != chunkInsertHeader {
+			results = append(results, chunk.text)
+			continue
+		}
+		results = append(results, "header:"+chunk.text)
+		err = scanner.batches(chunk.text, func(batch string) error {
+			results = append(results, batch)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if !reflect.DeepEqual(results, wants) {
+		t.Errorf("\nExpected:\n%q\nActual:\n%q", wants, results)
+	}
+}
+
+func TestBatchesTooBigForClause(t *testing.T) {
+
+	dump := "INSERT INTO `wp_posts` (`ID`) VALUES (1),(2),(3),(4),(5),(6) ON DUPLICATE KEY UPDATE `ID` = `ID` + 100;\n"
+
+	scanner := newSQLScanner(strings.NewReader(dump))
+	scanner.streamRows = true
+	scanner.batchSize = 40
+	scanner.lookahead = 1
+
+	chunk, err := scanner.next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var processed []string
+	err = scanner.batches(chunk.text, func(batch string) error {
+		processed = append(processed, batch)
+		return nil
+	})
+
+	// Rather than writing out batches without the clause, it gives up
+	if err == nil {
+		t.Error("Expected an error, got batches", processed)
+	}
+}
+
+func TestStreamRows(t *testing.T) {
+
+	config := Config{
+		Patterns: []ConfigPattern{
+			{
+				TableName: "wp_options",
+				DeleteRows: []PatternFieldConstraint{
+					{Field: "option_name", Operator: "like", Value: "\\_transient\\_%"},
+				},
+			},
+			{TableName: "wp_actionscheduler_logs", Action: "truncate"},
+		},
+		StreamRows: true,
+	}
+	query := "INSERT INTO `wp_options` (`option_id`, `option_name`, `option_value`) VALUES (1,'_transient_doing_cron','1564555155'),(2,'siteurl','https://www.client.com');\n" +
+		"INSERT INTO `wp_actionscheduler_logs` VALUES (1,'action created');\n" +
+		"INSERT INTO `wp_options` (`option_id`, `option_name`, `option_value`) VALUES (3,'_transient_foo','bar');\n" +
+		"UNLOCK TABLES;\n"
+	wants := "insert into wp_options(option_id, option_name, option_value) values (2, 'siteurl', 'https://www.client.com');\n" +
+		"UNLOCK TABLES;\n"
+
+	result := processString(t, config, query)
+
+	if result != wants {
+		t.Error("\nExpected:\n", wants, "\nActual:\n", result)
+	}
+}
@@ -0,0 +1,435 @@
+package main
+
+import (
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"github.com/xwb1989/sqlparser"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SubsetConfig cuts a dump down to a set of root rows and the rows related to
+// them, such as the most recent orders along with their items, customers and
+// products.
+type SubsetConfig struct {
+	Root          SubsetRoot           `json:"root"`
+	Relationships []SubsetRelationship `json:"relationships"`
+
+	// rows are the rows to keep, worked out by reading through the dump before
+	// processing it
+	rows *subsetRows
+}
+
+// SubsetRoot picks the rows the subset is built around.
+type SubsetRoot struct {
+	TableName string `json:"tableName"`
+	// Key is the column identifying each row of the root table.
+	Key string `json:"key"`
+	// Filter picks out the candidates for the root rows.
+	Filter []PatternFieldConstraint `json:"filter"`
+	// OrderBy and Descending decide which candidates make it past the Limit.
+	OrderBy    string `json:"orderBy"`
+	Descending bool   `json:"descending"`
+	// Limit is the number of root rows kept. 0 keeps every candidate.
+	Limit int `json:"limit"`
+}
+
+// SubsetRelationship is a foreign key from a column of one table to a column
+// of another.
+type SubsetRelationship struct {
+	TableName        string `json:"tableName"`
+	Column           string `json:"column"`
+	ReferencedTable  string `json:"referencedTable"`
+	ReferencedColumn string `json:"referencedColumn"`
+	// Constraints limit the relationship to some of the rows, for relationships
+	// that depend on the row, such as a meta value only referring to a post
+	// when its key is _thumbnail_id.
+	Constraints []PatternFieldConstraint `json:"constraints"`
+}
+
+func (s *SubsetConfig) validate() error {
+	if s.Root.TableName == "" || s.Root.Key == "" {
+		return fmt.Errorf("subset root requires a tableName and key")
+	}
+	if s.Root.Limit < 0 {
+		return fmt.Errorf("subset root limit can't be negative")
+	}
+	for _, constraint := range s.Root.Filter {
+		if err := constraint.validate(); err != nil {
+			return err
+		}
+	}
+	for _, relationship := range s.Relationships {
+		if relationship.TableName == "" || relationship.Column == "" || relationship.ReferencedTable == "" || relationship.ReferencedColumn == "" {
+			return fmt.Errorf("subset relationships require a tableName, column, referencedTable and referencedColumn")
+		}
+		for _, constraint := range relationship.Constraints {
+			if err := constraint.validate(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// subsetSlot is a column whose value decides whether a row is kept.
+type subsetSlot struct {
+	column string
+	// constraints leave the slot empty for rows they don't match
+	constraints []PatternFieldConstraint
+}
+
+// subsetTable is a table taking part in the subset. Rows are told apart by the
+// values of the table's slots only, as two rows with the same values there are
+// always kept or dropped together.
+type subsetTable struct {
+	slots []subsetSlot
+	// nodes are the distinct rows, keyed by their slot values
+	nodes map[string]*subsetNode
+}
+
+// subsetNode is one or more rows of a table with the same slot values.
+type subsetNode struct {
+	table  string
+	values []subsetValue
+	// included rows are the root rows and the rows belonging to them, while
+	// required rows are the ones referenced by the rows kept. Only included
+	// rows bring the rows belonging to them along.
+	included bool
+	required bool
+}
+
+type subsetValue struct {
+	value string
+	null  bool
+}
+
+// subsetLink is a relationship along with the slots holding its columns.
+type subsetLink struct {
+	table, referencedTable string
+	slot, referencedSlot   int
+}
+
+type subsetRows struct {
+	tables map[string]*subsetTable
+	links  []*subsetLink
+	// rootSlot is the slot of the root table holding its key
+	rootSlot int
+}
+
+func (s *SubsetConfig) newSubsetRows() *subsetRows {
+	rows := &subsetRows{tables: make(map[string]*subsetTable)}
+
+	rows.rootSlot = rows.addSlot(s.Root.TableName, subsetSlot{column: s.Root.Key})
+	for _, relationship := range s.Relationships {
+		rows.links = append(rows.links, &subsetLink{
+			table:           relationship.TableName,
+			referencedTable: relationship.ReferencedTable,
+			slot:            rows.addSlot(relationship.TableName, subsetSlot{column: relationship.Column, constraints: relationship.Constraints}),
+			referencedSlot:  rows.addSlot(relationship.ReferencedTable, subsetSlot{column: relationship.ReferencedColumn}),
+		})
+	}
+	return rows
+}
+
+// addSlot returns the index of the table's slot for the column, adding it if
+// it doesn't exist yet. Slots with constraints are never shared.
+func (r *subsetRows) addSlot(tableName string, slot subsetSlot) int {
+	table, ok := r.tables[tableName]
+	if !ok {
+		table = &subsetTable{nodes: make(map[string]*subsetNode)}
+		r.tables[tableName] = table
+	}
+
+	if slot.constraints == nil {
+		for i, existing := range table.slots {
+			if existing.constraints == nil && strings.EqualFold(existing.column, slot.column) {
+				return i
+			}
+		}
+	}
+	table.slots = append(table.slots, slot)
+	return len(table.slots) - 1
+}
+
+// rowValues returns the values of the table's slots for the row.
+func (t *subsetTable) rowValues(tableName string, row sqlparser.ValTuple, columns map[string]int) ([]subsetValue, error) {
+	values := make([]subsetValue, len(t.slots))
+	for i, slot := range t.slots {
+		index, err := resolveColumnIndex(slot.column, 0, columns)
+		if err != nil {
+			return nil, fmt.Errorf("can't find column %s of %s for subsetting: %s", slot.column, tableName, err)
+		}
+		if index >= len(row) || !rowMatchesAll(slot.constraints, row, columns) {
+			values[i].null = true
+			continue
+		}
+		values[i].value, values[i].null = exprToString(row[index])
+	}
+	return values, nil
+}
+
+func subsetKey(values []subsetValue) string {
+	var key strings.Builder
+	for _, value := range values {
+		if value.null {
+			key.WriteString("N")
+		} else {
+			key.WriteString("V" + strconv.Itoa(len(value.value)) + ":" + value.value)
+		}
+	}
+	return key.String()
+}
+
+// rootCandidate is a row of the root table matching the root filter.
+type rootCandidate struct {
+	key   string
+	order string
+}
+
+// prepare reads through the whole dump to work out which rows to keep, and
+// returns a reader to process the dump with afterwards. Input that can't be
+// read twice is copied to a temporary file as it's read.
+func (s *SubsetConfig) prepare(input io.Reader, streamRows bool) (io.Reader, error) {
+	// Stdin can be seeked when it's redirected from a file, but not when it's
+	// piped in
+	if seeker, ok := input.(io.ReadSeeker); ok && canSeek(seeker) {
+		if err := s.collect(seeker, streamRows); err != nil {
+			return nil, err
+		}
+		_, err := seeker.Seek(0, io.SeekStart)
+		return seeker, err
+	}
+
+	file, err := ioutil.TempFile("", "anonymize-mysqldump-")
+	if err != nil {
+		return nil, err
+	}
+	// The file stays readable until it's closed, and is cleaned up by the system
+	// even if we exit early
+	os.Remove(file.Name())
+
+	if err := s.collect(io.TeeReader(input, file), streamRows); err != nil {
+		return nil, err
+	}
+	_, err = file.Seek(0, io.SeekStart)
+	return file, err
+}
+
+func canSeek(seeker io.Seeker) bool {
+	_, err := seeker.Seek(0, io.SeekCurrent)
+	return err == nil
+}
+
+// collect reads the rows of the tables taking part in the subset, and works
+// out which of them to keep.
+func (s *SubsetConfig) collect(input io.Reader, streamRows bool) error {
+	rows := s.newSubsetRows()
+	schemas := newTableSchemas()
+	var candidates []rootCandidate
+
+	collectStatement := func(query string) error {
+		if _, ok := rows.tables[insertTableName(query)]; !ok {
+			return nil
+		}
+		parsed, err := parseLine(query)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error": err,
+			}).Warn("Failed parsing line while subsetting, its rows will be dropped")
+			return nil
+		}
+		insert, ok := parsed.(*sqlparser.Insert)
+		if !ok {
+			return nil
+		}
+		values, ok := insert.Rows.(sqlparser.Values)
+		if !ok {
+			return nil
+		}
+
+		tableName := insert.Table.Name.String()
+		table := rows.tables[tableName]
+		columns := schemas.columnsFor(tableName)
+		if len(insert.Columns) > 0 {
+			columns = columnsFromInsert(insert.Columns)
+		}
+
+		for _, row := range values {
+			rowValues, err := table.rowValues(tableName, row, columns)
+			if err != nil {
+				return err
+			}
+			key := subsetKey(rowValues)
+			if _, ok := table.nodes[key]; !ok {
+				table.nodes[key] = &subsetNode{table: tableName, values: rowValues}
+			}
+
+			if tableName == s.Root.TableName && !rowValues[rows.rootSlot].null && rowMatchesAll(s.Root.Filter, row, columns) {
+				candidate := rootCandidate{key: rowValues[rows.rootSlot].value}
+				if s.Root.OrderBy != "" {
+					index, err := resolveColumnIndex(s.Root.OrderBy, 0, columns)
+					if err != nil {
+						return err
+					}
+					if index < len(row) {
+						candidate.order, _ = exprToString(row[index])
+					}
+				}
+				candidates = append(candidates, candidate)
+			}
+		}
+		return nil
+	}
+
+	scanner := newSQLScanner(input)
+	scanner.streamRows = streamRows
+	for {
+		chunk, err := scanner.next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		if chunk.kind == chunkInsertHeader {
+			if err := scanner.batches(chunk.text, collectStatement); err != nil {
+				return err
+			}
+			continue
+		}
+		if chunk.kind != chunkStatement {
+			continue
+		}
+
+		if createTablePattern.MatchString(chunk.text) {
+			schemas.addFromCreateTable(chunk.text)
+			continue
+		}
+		if err := collectStatement(chunk.text); err != nil {
+			return err
+		}
+	}
+
+	rows.includeRoots(s.Root, candidates)
+	s.rows = rows
+	return nil
+}
+
+// includeRoots picks the root rows out of the candidates, and brings along
+// every row related to them.
+func (r *subsetRows) includeRoots(root SubsetRoot, candidates []rootCandidate) {
+	if root.OrderBy != "" {
+		sort.SliceStable(candidates, func(i, j int) bool {
+			if root.Descending {
+				return compareOrder(candidates[j].order, candidates[i].order)
+			}
+			return compareOrder(candidates[i].order, candidates[j].order)
+		})
+	}
+	if root.Limit > 0 && len(candidates) > root.Limit {
+		candidates = candidates[:root.Limit]
+	}
+
+	keys := make(map[string]bool, len(candidates))
+	for _, candidate := range candidates {
+		keys[candidate.key] = true
+	}
+
+	var queue []*subsetNode
+	for _, node := range r.tables[root.TableName].nodes {
+		if !node.values[r.rootSlot].null && keys[node.values[r.rootSlot].value] {
+			node.included = true
+			queue = append(queue, node)
+		}
+	}
+
+	// Index the rows on both ends of each relationship by the value linking them
+	children := make([]map[string][]*subsetNode, len(r.links))
+	parents := make([]map[string][]*subsetNode, len(r.links))
+	for i, link := range r.links {
+		children[i] = r.index(link.table, link.slot)
+		parents[i] = r.index(link.referencedTable, link.referencedSlot)
+	}
+
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+
+		for i, link := range r.links {
+			// The rows belonging to included rows are included too
+			if value := node.values[link.referencedSlot]; node.included && link.referencedTable == node.table && !value.null {
+				for _, child := range children[i][value.value] {
+					if !child.included {
+						child.included = true
+						queue = append(queue, child)
+					}
+				}
+			}
+
+			// The rows referenced by kept rows are required for the references
+			// to stay valid
+			if link.table != node.table {
+				continue
+			}
+			if value := node.values[link.slot]; !value.null {
+				for _, parent := range parents[i][value.value] {
+					if !parent.included && !parent.required {
+						parent.required = true
+						queue = append(queue, parent)
+					}
+				}
+			}
+		}
+	}
+}
+
+func (r *subsetRows) index(tableName string, slot int) map[string][]*subsetNode {
+	index := make(map[string][]*subsetNode)
+	for _, node := range r.tables[tableName].nodes {
+		if !node.values[slot].null {
+			index[node.values[slot].value] = append(index[node.values[slot].value], node)
+		}
+	}
+	return index
+}
+
+// compareOrder reports whether a sorts before b, comparing numerically when
+// both are numbers.
+func compareOrder(a, b string) bool {
+	aNumber, aErr := strconv.ParseFloat(a, 64)
+	bNumber, bErr := strconv.ParseFloat(b, 64)
+	if aErr == nil && bErr == nil {
+		return aNumber < bNumber
+	}
+	return a < b
+}
+
+// keepRows drops the rows of a table taking part in the subset that weren't
+// picked to be kept.
+func (s *SubsetConfig) keepRows(tableName string, values sqlparser.Values, columns map[string]int) (sqlparser.Values, error) {
+	if s.rows == nil {
+		return values, nil
+	}
+	table, ok := s.rows.tables[tableName]
+	if !ok {
+		// Tables that don't take part in the subset are kept whole
+		return values, nil
+	}
+
+	kept := values[:0]
+	for _, row := range values {
+		rowValues, err := table.rowValues(tableName, row, columns)
+		if err != nil {
+			return values, err
+		}
+		node, ok := table.nodes[subsetKey(rowValues)]
+		if ok && (node.included || node.required) {
+			kept = append(kept, row)
+		}
+	}
+	return kept, nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestSubset(t *testing.T) {
+
+	config := Config{
+		Subset: &SubsetConfig{
+			Root: SubsetRoot{
+				TableName: "wp_posts",
+				Key:       "ID",
+				Filter: []PatternFieldConstraint{
+					{Field: "post_type", Value: "shop_order"},
+				},
+				OrderBy:    "post_date",
+				Descending: true,
+				Limit:      2,
+			},
+			Relationships: []SubsetRelationship{
+				{TableName: "wp_postmeta", Column: "post_id", ReferencedTable: "wp_posts", ReferencedColumn: "ID"},
+				{TableName: "wp_posts", Column: "post_author", ReferencedTable: "wp_users", ReferencedColumn: "ID"},
+				{
+					TableName:        "wp_postmeta",
+					Column:           "meta_value",
+					ReferencedTable:  "wp_posts",
+					ReferencedColumn: "ID",
+					Constraints: []PatternFieldConstraint{
+						{Field: "meta_key", Value: "_product_id"},
+					},
+				},
+			},
+		},
+	}
+	query := "CREATE TABLE `wp_users` (\n" +
+		"  `ID` bigint(20) unsigned NOT NULL AUTO_INCREMENT,\n" +
+		"  `user_login` varchar(60) NOT NULL DEFAULT '',\n" +
+		"  PRIMARY KEY (`ID`)\n" +
+		");\n" +
+		"INSERT INTO `wp_options` (`option_id`, `option_name`) VALUES (1,'siteurl');\n" +
+		"INSERT INTO `wp_postmeta` (`meta_id`, `post_id`, `meta_key`, `meta_value`) VALUES (1,3,'_product_id','1'),(2,2,'_product_id','1'),(3,4,'_total','10'),(4,5,'_edit_lock','1');\n" +
+		"INSERT INTO `wp_posts` (`ID`, `post_author`, `post_date`, `post_type`) VALUES (1,1,'2019-01-01 00:00:00','product'),(2,2,'2019-02-01 00:00:00','shop_order'),(3,3,'2019-03-01 00:00:00','shop_order'),\n" +
+		"(4,3,'2019-04-01 00:00:00','shop_order'),(5,1,'2019-05-01 00:00:00','page');\n" +
+		"INSERT INTO `wp_users` VALUES (1,'admin'),(2,'jane'),(3,'john');\n"
+	wants := "CREATE TABLE `wp_users` (\n" +
+		"  `ID` bigint(20) unsigned NOT NULL AUTO_INCREMENT,\n" +
+		"  `user_login` varchar(60) NOT NULL DEFAULT '',\n" +
+		"  PRIMARY KEY (`ID`)\n" +
+		");\n" +
+		"insert into wp_options(option_id, option_name) values (1, 'siteurl');\n" +
+		"insert into wp_postmeta(meta_id, post_id, meta_key, meta_value) values (1, 3, '_product_id', '1'), (3, 4, '_total', '10');\n" +
+		"insert into wp_posts(ID, post_author, post_date, post_type) values (1, 1, '2019-01-01 00:00:00', 'product'), (3, 3, '2019-03-01 00:00:00', 'shop_order'), (4, 3, '2019-04-01 00:00:00', 'shop_order');\n" +
+		"insert into wp_users values (1, 'admin'), (3, 'john');\n"
+
+	result := processString(t, config, query)
+
+	if result != wants {
+		t.Error("\nExpected:\n", wants, "\nActual:\n", result)
+	}
+}
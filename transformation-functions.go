@@ -1,43 +1,449 @@
 package main
 
 import (
+	"encoding/hex"
+	"fmt"
+	"github.com/sirupsen/logrus"
 	"github.com/xwb1989/sqlparser"
-	"syreclabs.com/go/faker"
+	"math/big"
+	"strconv"
+	"strings"
+	"syreclabs.com/go/faker/locales"
+	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
-func generateUsername(value *sqlparser.SQLVal) *sqlparser.SQLVal {
-	return sqlparser.NewStrVal([]byte(faker.Internet().UserName()))
+// TransformationOptions holds the settings a field can pass on to its
+// transformation function. Each transformation only looks at the options that
+// make sense for it and falls back to its defaults for the others.
+type TransformationOptions struct {
+	// Length is the number of characters of generated passwords.
+	Length int `json:"length"`
+	// Words is the number of words of generated paragraphs.
+	Words int `json:"words"`
+	// Min and Max bound the numbers generated by randomNumber.
+	Min *float64 `json:"min"`
+	Max *float64 `json:"max"`
+	// Percent is how far noise can move a number, defaulting to 10.
+	Percent float64 `json:"percent"`
+	// Nearest is what round rounds numbers to, defaulting to 10.
+	Nearest float64 `json:"nearest"`
+	// Days is how far date can move a date, defaulting to 365.
+	Days int `json:"days"`
+	// Domain replaces the domain of generated emails and URLs.
+	Domain string `json:"domain"`
+	// Format is the template used by the format transformation, where every #
+	// is replaced with a random digit and every ? with a random letter.
+	Format string `json:"format"`
+	// Locale is the faker locale used to generate the value, such as "de" or
+	// "en-gb".
+	Locale string `json:"locale"`
+	// KeepFirst and KeepLast are the number of characters mask leaves visible
+	// at the start and end of the value.
+	KeepFirst int `json:"keepFirst"`
+	KeepLast  int `json:"keepLast"`
+	// MaskChar is the character mask replaces characters with, defaulting to *.
+	MaskChar string `json:"maskChar"`
+	// KeepPunctuation makes mask leave everything but letters and digits
+	// visible, such as the spaces between groups of digits of a card number.
+	KeepPunctuation bool `json:"keepPunctuation"`
+	// Detectors limits the kinds of personal data scrub looks for.
+	Detectors []string `json:"detectors"`
 }
 
-func generatePassword(value *sqlparser.SQLVal) *sqlparser.SQLVal {
+var (
+	fakerLocales = map[string]map[string]interface{}{
+		"de-at":       locales.De_AT,
+		"de-ch":       locales.De_CH,
+		"de":          locales.De,
+		"en-au-ocker": locales.En_AU_OCKER,
+		"en-au":       locales.En_AU,
+		"en-bork":     locales.En_BORK,
+		"en-ca":       locales.En_CA,
+		"en-gb":       locales.En_GB,
+		"en-ind":      locales.En_IND,
+		"en-nep":      locales.En_NEP,
+		"en-us":       locales.En_US,
+		"en":          locales.En,
+		"es":          locales.Es,
+		"fa":          locales.Fa,
+		"fr":          locales.Fr,
+		"it":          locales.It,
+		"ja":          locales.Ja,
+		"ko":          locales.Ko,
+		"nb-no":       locales.Nb_NO,
+		"nl":          locales.Nl,
+		"pl":          locales.Pl,
+		"pt-br":       locales.Pt_BR,
+		"ru":          locales.Ru,
+		"sk":          locales.Sk,
+		"sv":          locales.Sv,
+		"vi":          locales.Vi,
+		"zh-cn":       locales.Zh_CN,
+		"zh-tw":       locales.Zh_TW,
+	}
+)
+
+func (o TransformationOptions) validate() error {
+	if o.Locale != "" && fakerLocales[strings.ToLower(o.Locale)] == nil {
+		return fmt.Errorf("unknown locale %q", o.Locale)
+	}
+	if o.Length < 0 || o.Words < 0 || o.Percent < 0 || o.Nearest < 0 || o.Days < 0 || o.KeepFirst < 0 || o.KeepLast < 0 {
+		return fmt.Errorf("length, words, percent, nearest, days, keepFirst and keepLast options can't be negative")
+	}
+	if utf8.RuneCountInString(o.MaskChar) > 1 {
+		return fmt.Errorf("maskChar option has to be a single character")
+	}
+	if err := validateDetectors(o.Detectors); err != nil {
+		return err
+	}
+	if (o.Min == nil) != (o.Max == nil) {
+		return fmt.Errorf("min and max options have to be provided together")
+	}
+	if o.Min != nil && *o.Min > *o.Max {
+		return fmt.Errorf("min option is greater than max option")
+	}
+	return nil
+}
+
+func generateUsername(fake *fakeGenerator, value *sqlparser.SQLVal, options TransformationOptions) *sqlparser.SQLVal {
+	return newValOfType(value, fake.userName())
+}
+
+func generatePassword(fake *fakeGenerator, value *sqlparser.SQLVal, options TransformationOptions) *sqlparser.SQLVal {
 	// TODO encrypt this value
-	return sqlparser.NewStrVal([]byte(faker.Internet().Password(8, 14)))
+	if options.Length > 0 {
+		return newValOfType(value, fake.password(options.Length, options.Length))
+	}
+	return newValOfType(value, fake.password(8, 14))
+}
+
+func generateEmail(fake *fakeGenerator, value *sqlparser.SQLVal, options TransformationOptions) *sqlparser.SQLVal {
+	if options.Domain != "" {
+		return newValOfType(value, fake.userName()+"@"+options.Domain)
+	}
+	return newValOfType(value, fake.safeEmail())
+}
+
+func generateURL(fake *fakeGenerator, value *sqlparser.SQLVal, options TransformationOptions) *sqlparser.SQLVal {
+	if options.Domain != "" {
+		return newValOfType(value, fmt.Sprintf("http://%s/%s", options.Domain, fake.userName()))
+	}
+	return newValOfType(value, fake.url())
+}
+
+func generateName(fake *fakeGenerator, value *sqlparser.SQLVal, options TransformationOptions) *sqlparser.SQLVal {
+	return newValOfType(value, fake.name())
+}
+
+func generateFirstName(fake *fakeGenerator, value *sqlparser.SQLVal, options TransformationOptions) *sqlparser.SQLVal {
+	return newValOfType(value, fake.firstName())
+}
+
+func generateLastName(fake *fakeGenerator, value *sqlparser.SQLVal, options TransformationOptions) *sqlparser.SQLVal {
+	return newValOfType(value, fake.lastName())
+}
+
+func generateParagraph(fake *fakeGenerator, value *sqlparser.SQLVal, options TransformationOptions) *sqlparser.SQLVal {
+	words := 3
+	if options.Words > 0 {
+		words = options.Words
+	}
+	return newValOfType(value, fake.sentence(words))
+}
+
+func generateIPv4(fake *fakeGenerator, value *sqlparser.SQLVal, options TransformationOptions) *sqlparser.SQLVal {
+	return newValOfType(value, fake.ipv4())
+}
+
+// generateFormatted fills in the format option, e.g. "+44 #### ######".
+func generateFormatted(fake *fakeGenerator, value *sqlparser.SQLVal, options TransformationOptions) *sqlparser.SQLVal {
+	return newValOfType(value, fake.numerifyAndLetterify(options.Format))
+}
+
+// generateMasked hides every character of the value but the first and last
+// few, e.g. "**** **** **** 4242", so records can be recognised without
+// revealing the data.
+func generateMasked(fake *fakeGenerator, value *sqlparser.SQLVal, options TransformationOptions) *sqlparser.SQLVal {
+	maskChar := '*'
+	if options.MaskChar != "" {
+		maskChar, _ = utf8.DecodeRuneInString(options.MaskChar)
+	}
+
+	characters := []rune(literalString(value))
+	for i, char := range characters {
+		if i < options.KeepFirst || i >= len(characters)-options.KeepLast {
+			continue
+		}
+		if options.KeepPunctuation && !unicode.IsLetter(char) && !unicode.IsDigit(char) {
+			continue
+		}
+		characters[i] = maskChar
+	}
+	return newValOfType(value, string(characters))
 }
 
-func generateEmail(value *sqlparser.SQLVal) *sqlparser.SQLVal {
-	return sqlparser.NewStrVal([]byte(faker.Internet().SafeEmail()))
+// generateScrambled replaces each letter with a random letter of the same
+// case and each digit with a random digit, leaving punctuation and the length
+// of the value as they were.
+func generateScrambled(fake *fakeGenerator, value *sqlparser.SQLVal, options TransformationOptions) *sqlparser.SQLVal {
+	characters := []rune(literalString(value))
+	for i, char := range characters {
+		switch {
+		case unicode.IsDigit(char):
+			characters[i] = rune('0' + fake.randomInt(0, 9))
+		case unicode.IsUpper(char):
+			characters[i] = rune('A' + fake.randomInt(0, 25))
+		case unicode.IsLetter(char):
+			characters[i] = rune('a' + fake.randomInt(0, 25))
+		}
+	}
+	return newValOfType(value, string(characters))
 }
 
-func generateURL(value *sqlparser.SQLVal) *sqlparser.SQLVal {
-	return sqlparser.NewStrVal([]byte(faker.Internet().Url()))
+// generateRandomNumber replaces a number with a random one between the min
+// and max options. Without them, the random number has as many digits before
+// and after the decimal point as the original, so an age stays a believable
+// age.
+func generateRandomNumber(fake *fakeGenerator, value *sqlparser.SQLVal, options TransformationOptions) *sqlparser.SQLVal {
+	number, decimals, ok := parseNumber(value)
+	if !ok {
+		return skipNonNumeric(value, "randomNumber")
+	}
+
+	if options.Min != nil && options.Max != nil {
+		return formatNumber(value, newNumber(*options.Min+fake.randomFraction()*(*options.Max-*options.Min)), decimals)
+	}
+
+	whole, _ := number.Int(nil)
+	digits := len(whole.Abs(whole).String())
+	min := pow10(digits - 1)
+	if digits == 1 {
+		min = newNumber(0)
+	}
+	max := pow10(digits)
+	if limit := integerLimit(whole, number.Sign() < 0); decimals == 0 && limit != nil && max.Cmp(limit) > 0 {
+		max = limit
+	}
+	// Truncate rather than round so we never end up with an extra digit
+	scale := pow10(decimals)
+	random := new(big.Float).Sub(max, min)
+	random.Mul(random, newNumber(fake.randomFraction())).Add(random, min).Mul(random, scale)
+	truncated, _ := random.Int(nil)
+	random.SetInt(truncated).Quo(random, scale)
+	if number.Sign() < 0 {
+		random.Neg(random)
+	}
+	return formatNumber(value, random, decimals)
 }
 
-func generateName(value *sqlparser.SQLVal) *sqlparser.SQLVal {
-	return sqlparser.NewStrVal([]byte(faker.Name().Name()))
+// integerLimit returns one more than the largest magnitude of the smallest
+// of the BIGINT and BIGINT UNSIGNED types the integer fits in, so that a
+// random number with as many digits as a big ID doesn't overflow its column.
+// Integers too big for either have no limit.
+func integerLimit(magnitude *big.Int, negative bool) *big.Float {
+	limits := []*big.Int{
+		new(big.Int).Lsh(big.NewInt(1), 63),
+		new(big.Int).Lsh(big.NewInt(1), 64),
+	}
+	if negative {
+		// The smallest BIGINT is one further from zero than the largest
+		limits = []*big.Int{new(big.Int).Add(limits[0], big.NewInt(1))}
+	}
+	for _, limit := range limits {
+		if magnitude.Cmp(limit) < 0 {
+			return new(big.Float).SetPrec(numberPrecision).SetInt(limit)
+		}
+	}
+	return nil
 }
 
-func generateFirstName(value *sqlparser.SQLVal) *sqlparser.SQLVal {
-	return sqlparser.NewStrVal([]byte(faker.Name().FirstName()))
+// generateNoise moves a number up or down by up to the percent option (10% by
+// default) of its value, so totals and averages stay in the right ballpark
+// without revealing the original.
+func generateNoise(fake *fakeGenerator, value *sqlparser.SQLVal, options TransformationOptions) *sqlparser.SQLVal {
+	number, decimals, ok := parseNumber(value)
+	if !ok {
+		return skipNonNumeric(value, "noise")
+	}
+
+	percent := 10.0
+	if options.Percent > 0 {
+		percent = options.Percent
+	}
+	noise := (fake.randomFraction()*2 - 1) * percent / 100
+	return formatNumber(value, number.Mul(number, newNumber(1+noise)), decimals)
 }
 
-func generateLastName(value *sqlparser.SQLVal) *sqlparser.SQLVal {
-	return sqlparser.NewStrVal([]byte(faker.Name().LastName()))
+// generateRounded rounds a number to the nearest multiple of the nearest
+// option, or 10 by default.
+func generateRounded(fake *fakeGenerator, value *sqlparser.SQLVal, options TransformationOptions) *sqlparser.SQLVal {
+	number, decimals, ok := parseNumber(value)
+	if !ok {
+		return skipNonNumeric(value, "round")
+	}
+
+	nearest := newNumber(10)
+	if options.Nearest > 0 {
+		nearest = newNumber(options.Nearest)
+	}
+	multiple := new(big.Float).SetInt(roundNumber(number.Quo(number, nearest)))
+	return formatNumber(value, multiple.Mul(multiple, nearest), decimals)
+}
+
+// dateLayouts are the formats MySQL writes DATE, DATETIME and TIMESTAMP values
+// out in, longest first.
+var dateLayouts = []string{
+	"2006-01-02 15:04:05.000000",
+	"2006-01-02 15:04:05.00000",
+	"2006-01-02 15:04:05.0000",
+	"2006-01-02 15:04:05.000",
+	"2006-01-02 15:04:05.00",
+	"2006-01-02 15:04:05.0",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+// generateDate moves a date up to the days option (365 by default) earlier or
+// later, keeping its format, so that birthdays and order dates stay plausible
+// without giving away the originals. Dates stay dates and date times stay
+// date times.
+func generateDate(fake *fakeGenerator, value *sqlparser.SQLVal, options TransformationOptions) *sqlparser.SQLVal {
+	raw := literalString(value)
+	for _, layout := range dateLayouts {
+		date, err := time.Parse(layout, raw)
+		if err != nil {
+			continue
+		}
+
+		days := 365
+		if options.Days > 0 {
+			days = options.Days
+		}
+		step := time.Second
+		if len(layout) == len("2006-01-02") {
+			step = 24 * time.Hour
+		}
+		span := int64(time.Duration(days) * 24 * time.Hour / step)
+		offset := time.Duration(fake.randomInt64(-span, span)) * step
+		return newValOfType(value, date.Add(offset).Format(layout))
+	}
+
+	// Zero dates such as 0000-00-00 and anything else that isn't a date
+	logrus.WithFields(logrus.Fields{
+		"type": "date",
+	}).Warn("Skipping date transformation of value that isn't a date")
+	return value
+}
+
+// newValOfType wraps a generated value in the same kind of literal as the
+// original value, so that hex literals stay hex literals and numbers stay
+// unquoted when the generated value is a number.
+func newValOfType(original *sqlparser.SQLVal, generated string) *sqlparser.SQLVal {
+	switch original.Type {
+	case sqlparser.HexVal:
+		return sqlparser.NewHexVal([]byte(strings.ToUpper(hex.EncodeToString([]byte(generated)))))
+	case sqlparser.HexNum:
+		return sqlparser.NewHexNum([]byte("0x" + strings.ToUpper(hex.EncodeToString([]byte(generated)))))
+	case sqlparser.IntVal:
+		if _, err := strconv.ParseInt(generated, 10, 64); err == nil {
+			return sqlparser.NewIntVal([]byte(generated))
+		}
+	case sqlparser.FloatVal:
+		if _, err := strconv.ParseFloat(generated, 64); err == nil {
+			return sqlparser.NewFloatVal([]byte(generated))
+		}
+	}
+	return sqlparser.NewStrVal([]byte(generated))
+}
+
+// literalString returns the text a literal stands for, decoding hex literals.
+func literalString(value *sqlparser.SQLVal) string {
+	switch value.Type {
+	case sqlparser.HexVal:
+		if decoded, err := hex.DecodeString(string(value.Val)); err == nil {
+			return string(decoded)
+		}
+	case sqlparser.HexNum:
+		if decoded, err := hex.DecodeString(string(value.Val[2:])); err == nil {
+			return string(decoded)
+		}
+	}
+	return string(value.Val)
+}
+
+// numberPrecision is the number of bits numbers are worked out with, which is
+// plenty for every digit of a BIGINT or a DECIMAL(65), unlike a float64.
+const numberPrecision = 256
+
+func newNumber(number float64) *big.Float {
+	return new(big.Float).SetPrec(numberPrecision).SetFloat64(number)
+}
+
+func pow10(exponent int) *big.Float {
+	power := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(exponent)), nil)
+	return new(big.Float).SetPrec(numberPrecision).SetInt(power)
+}
+
+// roundNumber rounds a number to the nearest integer, halves away from zero.
+func roundNumber(number *big.Float) *big.Int {
+	half := newNumber(0.5)
+	if number.Sign() < 0 {
+		half.Neg(half)
+	}
+	rounded, _ := new(big.Float).SetPrec(numberPrecision).Add(number, half).Int(nil)
+	return rounded
+}
+
+// parseNumber reads the numeric value of a literal, along with the number of
+// digits after its decimal point.
+func parseNumber(value *sqlparser.SQLVal) (*big.Float, int, bool) {
+	if value.Type == sqlparser.HexNum {
+		number, ok := new(big.Int).SetString(string(value.Val[2:]), 16)
+		if !ok {
+			return nil, 0, false
+		}
+		return new(big.Float).SetPrec(numberPrecision).SetInt(number), 0, true
+	}
+
+	raw := string(value.Val)
+	number, ok := new(big.Float).SetPrec(numberPrecision).SetString(raw)
+	if !ok || number.IsInf() {
+		return nil, 0, false
+	}
+
+	decimals := 0
+	if point := strings.Index(raw, "."); point != -1 && !strings.ContainsAny(raw, "eE") {
+		decimals = len(raw) - point - 1
+	}
+	return number, decimals, true
 }
 
-func generateParagraph(value *sqlparser.SQLVal) *sqlparser.SQLVal {
-	return sqlparser.NewStrVal([]byte(faker.Lorem().Sentence(3)))
+// formatNumber writes a number out as the same kind of literal as the
+// original value, with the given number of digits after the decimal point.
+func formatNumber(original *sqlparser.SQLVal, number *big.Float, decimals int) *sqlparser.SQLVal {
+	switch original.Type {
+	case sqlparser.IntVal:
+		return sqlparser.NewIntVal([]byte(roundNumber(number).String()))
+	case sqlparser.HexNum:
+		rounded := roundNumber(number)
+		if rounded.Sign() < 0 {
+			rounded.SetInt64(0)
+		}
+		return sqlparser.NewHexNum([]byte(fmt.Sprintf("0x%X", rounded)))
+	case sqlparser.FloatVal:
+		return sqlparser.NewFloatVal([]byte(number.Text('f', decimals)))
+	}
+	return sqlparser.NewStrVal([]byte(number.Text('f', decimals)))
 }
 
-func generateIPv4(value *sqlparser.SQLVal) *sqlparser.SQLVal {
-	return sqlparser.NewStrVal([]byte(faker.Internet().IpV4Address()))
+// skipNonNumeric logs that a value given to a numeric transformation wasn't a
+// number and leaves it as it is. The value itself isn't logged, as it's
+// exactly the kind of data that shouldn't end up in logs.
+func skipNonNumeric(value *sqlparser.SQLVal, transformation string) *sqlparser.SQLVal {
+	logrus.WithFields(logrus.Fields{
+		"type": transformation,
+	}).Warn("Skipping numeric transformation of value that isn't a number")
+	return value
 }
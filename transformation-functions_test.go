@@ -0,0 +1,287 @@
+package main
+
+import (
+	"github.com/xwb1989/sqlparser"
+	"math"
+	"math/big"
+	"strconv"
+	"testing"
+)
+
+func TestTransformationsPreserveType(t *testing.T) {
+	fake := newFakeGenerator(432)
+
+	var tests = []struct {
+		testName       string
+		transformation string
+		value          *sqlparser.SQLVal
+		wants          string
+	}{
+		{
+			testName:       "string",
+			transformation: "firstName",
+			value:          sqlparser.NewStrVal([]byte("John")),
+			wants:          "'Nat'",
+		},
+		{
+			testName:       "hex string",
+			transformation: "firstName",
+			value:          sqlparser.NewHexVal([]byte("4A6F686E")),
+			wants:          "X'4B6169746C696E'",
+		},
+		{
+			testName:       "hex number",
+			transformation: "firstName",
+			value:          sqlparser.NewHexNum([]byte("0x4A6F686E")),
+			wants:          "0x5472657661",
+		},
+		{
+			testName:       "integer",
+			transformation: "randomNumber",
+			value:          sqlparser.NewIntVal([]byte("42")),
+			wants:          "97",
+		},
+		{
+			testName:       "decimal",
+			transformation: "randomNumber",
+			value:          sqlparser.NewFloatVal([]byte("1234.50")),
+			wants:          "2097.60",
+		},
+		{
+			testName:       "quoted decimal",
+			transformation: "round",
+			value:          sqlparser.NewStrVal([]byte("52345.67")),
+			wants:          "'52350.00'",
+		},
+		{
+			testName:       "text given to a numeric transformation",
+			transformation: "noise",
+			value:          sqlparser.NewStrVal([]byte("not a number")),
+			wants:          "'not a number'",
+		},
+		{
+			testName:       "integer too big for a float",
+			transformation: "round",
+			value:          sqlparser.NewIntVal([]byte("9007199254740993")),
+			wants:          "9007199254740990",
+		},
+		{
+			testName:       "date",
+			transformation: "date",
+			value:          sqlparser.NewStrVal([]byte("2019-06-12")),
+			wants:          "'2019-03-13'",
+		},
+		{
+			testName:       "date time",
+			transformation: "date",
+			value:          sqlparser.NewStrVal([]byte("2019-06-12 00:59:19")),
+			wants:          "'2018-08-19 21:46:21'",
+		},
+		{
+			testName:       "zero date",
+			transformation: "date",
+			value:          sqlparser.NewStrVal([]byte("0000-00-00 00:00:00")),
+			wants:          "'0000-00-00 00:00:00'",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.testName, func(t *testing.T) {
+			result := sqlparser.String(transformationFunctionMap[test.transformation](fake, test.value, TransformationOptions{}))
+			if result != test.wants {
+				t.Error("\nExpected:\n", test.wants, "\nActual:\n", result)
+			}
+		})
+	}
+}
+
+func TestNoiseStaysWithinTenPercent(t *testing.T) {
+	fake := newFakeGenerator(432)
+	for i := 0; i < 100; i++ {
+		result := generateNoise(fake, sqlparser.NewIntVal([]byte("1000")), TransformationOptions{})
+		if result.Type != sqlparser.IntVal {
+			t.Fatal("Expected an integer, got", sqlparser.String(result))
+		}
+
+		number, err := strconv.Atoi(string(result.Val))
+		if err != nil || math.Abs(float64(number-1000)) > 100 {
+			t.Error("Expected a number within 10% of 1000, got", string(result.Val))
+		}
+	}
+}
+
+func TestRandomNumberFitsBigIntColumns(t *testing.T) {
+	fake := newFakeGenerator(432)
+
+	var tests = []struct {
+		value string
+		min   string
+		max   string
+	}{
+		{value: "18446744073709551615", min: "10000000000000000000", max: "18446744073709551615"},
+		{value: "9223372036854775807", min: "1000000000000000000", max: "9223372036854775807"},
+		{value: "-9223372036854775808", min: "-9223372036854775808", max: "-1000000000000000000"},
+	}
+
+	for _, test := range tests {
+		min, _ := new(big.Int).SetString(test.min, 10)
+		max, _ := new(big.Int).SetString(test.max, 10)
+		for i := 0; i < 1000; i++ {
+			result := generateRandomNumber(fake, sqlparser.NewIntVal([]byte(test.value)), TransformationOptions{})
+
+			number, ok := new(big.Int).SetString(string(result.Val), 10)
+			if !ok || number.Cmp(min) < 0 || number.Cmp(max) > 0 {
+				t.Errorf("%s: expected a number between %s and %s, got %s", test.value, test.min, test.max, result.Val)
+				break
+			}
+		}
+	}
+}
+
+func TestTransformationOptions(t *testing.T) {
+	fake := newFakeGenerator(432)
+
+	min, max := 18.0, 21.0
+	var tests = []struct {
+		testName       string
+		transformation string
+		options        TransformationOptions
+		value          *sqlparser.SQLVal
+		wants          string
+	}{
+		{
+			testName:       "password length",
+			transformation: "password",
+			options:        TransformationOptions{Length: 20},
+			value:          sqlparser.NewStrVal([]byte("hunter2")),
+			wants:          "'slmF7sWvqGZXzo4yKwV0'",
+		},
+		{
+			testName:       "paragraph words",
+			transformation: "paragraph",
+			options:        TransformationOptions{Words: 5},
+			value:          sqlparser.NewStrVal([]byte("Lorum ipsum.")),
+			wants:          "'Enim odio nihil sunt non.'",
+		},
+		{
+			testName:       "email domain",
+			transformation: "email",
+			options:        TransformationOptions{Domain: "staging.local"},
+			value:          sqlparser.NewStrVal([]byte("hosting@humanmade.com")),
+			wants:          "'fatima.fisher@staging.local'",
+		},
+		{
+			testName:       "format",
+			transformation: "format",
+			options:        TransformationOptions{Format: "+44 #### ######"},
+			value:          sqlparser.NewStrVal([]byte("+44 1632 960123")),
+			wants:          "'+44 3406 336013'",
+		},
+		{
+			testName:       "number range",
+			transformation: "randomNumber",
+			options:        TransformationOptions{Min: &min, Max: &max},
+			value:          sqlparser.NewIntVal([]byte("42")),
+			wants:          "19",
+		},
+		{
+			testName:       "mask card number",
+			transformation: "mask",
+			options:        TransformationOptions{KeepLast: 4, KeepPunctuation: true},
+			value:          sqlparser.NewStrVal([]byte("4242 4242 4242 4242")),
+			wants:          "'**** **** **** 4242'",
+		},
+		{
+			testName:       "mask phone number",
+			transformation: "mask",
+			options:        TransformationOptions{KeepFirst: 4, KeepLast: 4, MaskChar: "#"},
+			value:          sqlparser.NewStrVal([]byte("+44 7700901234")),
+			wants:          "'+44 ######1234'",
+		},
+		{
+			testName:       "mask shorter than the characters kept",
+			transformation: "mask",
+			options:        TransformationOptions{KeepFirst: 4, KeepLast: 4},
+			value:          sqlparser.NewStrVal([]byte("abc")),
+			wants:          "'abc'",
+		},
+		{
+			testName:       "scramble",
+			transformation: "scramble",
+			value:          sqlparser.NewStrVal([]byte("AB12 3CD, flat 4")),
+			wants:          "'MC15 9HE, dmbt 6'",
+		},
+		{
+			testName:       "round to nearest",
+			transformation: "round",
+			options:        TransformationOptions{Nearest: 1000},
+			value:          sqlparser.NewIntVal([]byte("52345")),
+			wants:          "52000",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.testName, func(t *testing.T) {
+			if err := test.options.validate(); err != nil {
+				t.Fatal(err)
+			}
+
+			result := sqlparser.String(transformationFunctionMap[test.transformation](fake, test.value, test.options))
+			if result != test.wants {
+				t.Error("\nExpected:\n", test.wants, "\nActual:\n", result)
+			}
+		})
+	}
+}
+
+func TestTransformationOptionsValidate(t *testing.T) {
+	min, max := 10.0, 1.0
+	for _, options := range []TransformationOptions{
+		{Locale: "tlh"},
+		{Min: &min},
+		{Min: &min, Max: &max},
+		{Length: -1},
+		{MaskChar: "**"},
+	} {
+		if err := options.validate(); err == nil {
+			t.Errorf("Expected an error validating %+v", options)
+		}
+	}
+}
+
+func TestTransformationsInEveryLocale(t *testing.T) {
+	values := map[string]*sqlparser.SQLVal{
+		"randomNumber": sqlparser.NewIntVal([]byte("42")),
+		"noise":        sqlparser.NewIntVal([]byte("42")),
+		"round":        sqlparser.NewIntVal([]byte("42")),
+		"date":         sqlparser.NewStrVal([]byte("2019-07-31")),
+	}
+	options := TransformationOptions{Format: "+44 #### ??????"}
+
+	for locale, data := range fakerLocales {
+		for transformation, transform := range transformationFunctionMap {
+			value, ok := values[transformation]
+			if !ok {
+				value = sqlparser.NewStrVal([]byte("John Smith"))
+			}
+
+			func() {
+				defer func() {
+					if err := recover(); err != nil {
+						t.Errorf("%s in locale %s: %v", transformation, locale, err)
+					}
+				}()
+
+				// Enough seeds to go down every branch of the locale's formats
+				for seed := int64(0); seed < 50; seed++ {
+					fake := newFakeGenerator(seed)
+					fake.locale = data
+					if result := literalString(transform(fake, value, options)); result == "" {
+						t.Errorf("%s in locale %s: expected a value, got nothing", transformation, locale)
+						return
+					}
+				}
+			}()
+		}
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"github.com/sirupsen/logrus"
+	"github.com/xwb1989/sqlparser"
+	"regexp"
+	"strings"
+)
+
+var updateTablePattern = regexp.MustCompile("(?i)^\\s*UPDATE\\s+(?:(?:LOW_PRIORITY|IGNORE)\\s+)*" + tableNamePattern)
+
+// equalityOperators are the comparisons whose values are anonymized.
+var equalityOperators = map[string]bool{
+	sqlparser.EqualStr:         true,
+	sqlparser.NullSafeEqualStr: true,
+	sqlparser.InStr:            true,
+}
+
+// updateTableName returns the table an UPDATE statement changes, without
+// having to parse the whole statement.
+func updateTableName(query string) string {
+	match := updateTablePattern.FindStringSubmatch(query)
+	if match == nil {
+		return ""
+	}
+	return match[1]
+}
+
+// applyConfigToUpdate anonymizes the values an UPDATE statement sets
+// configured fields to, along with the values its WHERE clause compares them
+// with, so that they don't give away the original values either.
+func applyConfigToUpdate(stmt *sqlparser.Update, config Config, schemas *TableSchemas, pseudonymizer *Pseudonymizer) (*sqlparser.Update, error) {
+
+	table := updatedTable(stmt)
+	if table == "" {
+		logrus.WithFields(logrus.Fields{
+			"query": sqlparser.String(stmt),
+		}).Warn("Skipping UPDATE statement changing more than one table")
+		return stmt, nil
+	}
+
+	columns := schemas.columnsFor(table)
+	var comparisons []*sqlparser.ComparisonExpr
+	if stmt.Where != nil {
+		comparisons = columnComparisons(stmt.Where.Expr)
+	}
+
+	// Constraints are checked against what's known about the row being
+	// changed, before any of it is anonymized
+	row := newUpdatedRow(stmt, columns)
+
+	for _, pattern := range config.Patterns {
+		if table != pattern.TableName {
+			continue
+		}
+
+		matches := func(constraints []PatternFieldConstraint) bool {
+			return row.matchesAll(constraints, pattern)
+		}
+
+		modifyAssignments(stmt.Exprs, pattern, columns, pseudonymizer, matches)
+
+		for _, comparison := range comparisons {
+			column := comparison.Left.(*sqlparser.ColName).Name.Lowered()
+			for _, fieldPattern := range pattern.Fields {
+				if !assignsField(column, fieldPattern, columns) || !isTransformationType(fieldPattern.Type) {
+					continue
+				}
+				if fieldPattern.Constraints != nil && !matches(fieldPattern.Constraints) {
+					continue
+				}
+				for _, value := range comparedValues(comparison) {
+					*value = transformExpr(*value, fieldPattern, pseudonymizer)
+				}
+			}
+		}
+	}
+
+	if replacer := config.replacer; replacer != nil {
+		replaceAssignments(stmt.Exprs, replacer)
+		for _, comparison := range comparisons {
+			values := comparedValues(comparison)
+			compared := make(sqlparser.ValTuple, len(values))
+			for i, value := range values {
+				compared[i] = *value
+			}
+			applyReplacements(sqlparser.Values{compared}, replacer)
+			for i, value := range values {
+				*value = compared[i]
+			}
+		}
+	}
+
+	return stmt, nil
+}
+
+// updatedTable returns the table an UPDATE statement changes, or an empty
+// string when it changes several tables at once.
+func updatedTable(stmt *sqlparser.Update) string {
+	if len(stmt.TableExprs) != 1 {
+		return ""
+	}
+	aliased, ok := stmt.TableExprs[0].(*sqlparser.AliasedTableExpr)
+	if !ok {
+		return ""
+	}
+	name, ok := aliased.Expr.(sqlparser.TableName)
+	if !ok {
+		return ""
+	}
+	return name.Name.String()
+}
+
+// columnComparisons returns the comparisons anywhere in the expression of a
+// column being equal to one or more literal values, such as user_email =
+// 'foo@bar.com' or ID IN (1, 2). Other comparisons, such as LIKE patterns and
+// ranges, are left alone, as replacing their values would change which rows
+// they match without hiding anything.
+func columnComparisons(expr sqlparser.Expr) []*sqlparser.ComparisonExpr {
+	var comparisons []*sqlparser.ComparisonExpr
+	sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+		comparison, ok := node.(*sqlparser.ComparisonExpr)
+		if !ok {
+			return true, nil
+		}
+		if !equalityOperators[comparison.Operator] {
+			return false, nil
+		}
+		if _, ok := comparison.Left.(*sqlparser.ColName); ok && len(comparedValues(comparison)) > 0 {
+			comparisons = append(comparisons, comparison)
+		}
+		return false, nil
+	}, expr)
+	return comparisons
+}
+
+// comparedValues returns the literal values a column is compared with, so
+// they can be swapped out.
+func comparedValues(comparison *sqlparser.ComparisonExpr) []*sqlparser.Expr {
+	switch right := comparison.Right.(type) {
+	case *sqlparser.SQLVal:
+		return []*sqlparser.Expr{&comparison.Right}
+	case sqlparser.ValTuple:
+		var values []*sqlparser.Expr
+		for i := range right {
+			if _, ok := right[i].(*sqlparser.SQLVal); ok {
+				values = append(values, &right[i])
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// updatedRow is what's known about a row changed by an UPDATE statement: the
+// values its WHERE clause requires some columns to equal, and the values
+// it sets columns to.
+type updatedRow struct {
+	columns map[string]int
+	row     sqlparser.ValTuple
+	// positional is whether the columns are the table's, so that fields and
+	// constraints can be found by position too
+	positional bool
+}
+
+func newUpdatedRow(stmt *sqlparser.Update, tableColumns map[string]int) *updatedRow {
+	row := &updatedRow{columns: make(map[string]int)}
+	if tableColumns != nil {
+		row.columns = tableColumns
+		row.row = make(sqlparser.ValTuple, len(tableColumns))
+		row.positional = true
+	}
+
+	if stmt.Where != nil {
+		for _, condition := range andedConditions(stmt.Where.Expr) {
+			comparison, ok := condition.(*sqlparser.ComparisonExpr)
+			if !ok || comparison.Operator != sqlparser.EqualStr {
+				continue
+			}
+			column, ok := comparison.Left.(*sqlparser.ColName)
+			value, isLiteral := comparison.Right.(*sqlparser.SQLVal)
+			if ok && isLiteral {
+				row.set(column.Name.Lowered(), value)
+			}
+		}
+	}
+
+	// The row ends up with the values it's set to, whatever they were before
+	for _, assignment := range stmt.Exprs {
+		switch assignment.Expr.(type) {
+		case *sqlparser.SQLVal, *sqlparser.NullVal:
+			row.set(assignment.Name.Name.Lowered(), assignment.Expr)
+		}
+	}
+
+	return row
+}
+
+// andedConditions splits an expression into the conditions that all have to
+// be met for it to be.
+func andedConditions(expr sqlparser.Expr) []sqlparser.Expr {
+	switch expr := expr.(type) {
+	case *sqlparser.AndExpr:
+		return append(andedConditions(expr.Left), andedConditions(expr.Right)...)
+	case *sqlparser.ParenExpr:
+		return andedConditions(expr.Expr)
+	}
+	return []sqlparser.Expr{expr}
+}
+
+func (r *updatedRow) set(column string, value sqlparser.Expr) {
+	index, ok := r.columns[column]
+	if !ok {
+		if r.positional {
+			// Not one of the table's columns, so MySQL would fail on it anyway
+			return
+		}
+		index = len(r.row)
+		r.columns[column] = index
+		r.row = append(r.row, nil)
+	}
+	r.row[index] = value
+}
+
+// knows reports whether the value a constraint compares is known.
+func (r *updatedRow) knows(constraint PatternFieldConstraint) bool {
+	index, ok := r.columns[strings.ToLower(constraint.Field)]
+	if !ok && r.positional && constraint.Position > 0 {
+		index, ok = constraint.Position-1, true
+	}
+	return ok && index < len(r.row) && r.row[index] != nil
+}
+
+// matchesAll reports whether the row obeys every one of the constraints. When
+// the statement doesn't say enough about the row to tell, the constraints are
+// taken to match, as it's safer to anonymize a value we didn't have to than to
+// leave one we should have.
+func (r *updatedRow) matchesAll(constraints []PatternFieldConstraint, pattern ConfigPattern) bool {
+	for _, constraint := range constraints {
+		known := true
+		constraint.walk(func(c PatternFieldConstraint) error {
+			known = known && (c.isGroup() || r.knows(c))
+			return nil
+		})
+		if !known {
+			logrus.WithFields(logrus.Fields{
+				"table": pattern.TableName,
+				"field": constraint.Field,
+			}).Warn("Can't tell whether the row changed by an UPDATE statement meets the constraints, anonymizing it anyway")
+			return true
+		}
+	}
+	return rowMatchesAll(constraints, r.row, r.columns)
+}
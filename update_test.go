@@ -0,0 +1,94 @@
+package main
+
+import (
+	"syreclabs.com/go/faker"
+	"testing"
+)
+
+func TestUpdates(t *testing.T) {
+
+	config := Config{
+		Patterns: []ConfigPattern{
+			{
+				TableName: "wp_users",
+				Fields: []PatternField{
+					{Field: "user_email", Type: "email"},
+				},
+			},
+			{
+				TableName: "wp_usermeta",
+				Fields: []PatternField{
+					{
+						Field: "meta_value",
+						Type:  "firstName",
+						Constraints: []PatternFieldConstraint{
+							{Field: "meta_key", Value: "first_name"},
+						},
+					},
+				},
+			},
+		},
+		Replacements: []Replacement{
+			{Search: "https://www.client.com", Replace: "https://example.com"},
+		},
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		wants string
+	}{
+		{
+			name:  "set",
+			query: "UPDATE `wp_users` SET `user_email`='hosting@humanmade.com', `user_url`='https://www.client.com' WHERE `ID`=5;\n",
+			wants: "update wp_users set user_email = 'elinor@example.org', user_url = 'https://example.com' where ID = 5;\n",
+		},
+		{
+			name:  "where",
+			query: "UPDATE `wp_users` SET `user_status`=1 WHERE `user_email` IN ('hosting@humanmade.com', 'admin@humanmade.com');\n",
+			wants: "update wp_users set user_status = 1 where user_email in ('elinor@example.org', 'cordelia@example.net');\n",
+		},
+		{
+			name:  "null-safe equal",
+			query: "UPDATE `wp_users` SET `user_status`=1 WHERE `user_email` <=> 'hosting@humanmade.com';\n",
+			wants: "update wp_users set user_status = 1 where user_email <=> 'elinor@example.org';\n",
+		},
+		{
+			name:  "other comparisons",
+			query: "UPDATE `wp_users` SET `user_status`=1 WHERE `user_email` LIKE '%@humanmade.com' OR `user_email` > 'a' OR `user_email` BETWEEN 'a' AND 'b';\n",
+			wants: "update wp_users set user_status = 1 where user_email like '%@humanmade.com' or user_email > 'a' or user_email between 'a' and 'b';\n",
+		},
+		{
+			name:  "constraints met",
+			query: "UPDATE `wp_usermeta` SET `meta_value`='Jane' WHERE `user_id`=5 AND `meta_key`='first_name';\n",
+			wants: "update wp_usermeta set meta_value = 'Reece' where user_id = 5 and meta_key = 'first_name';\n",
+		},
+		{
+			name:  "constraints not met",
+			query: "UPDATE `wp_usermeta` SET `meta_value`='Jane' WHERE `user_id`=5 AND `meta_key`='nickname';\n",
+			wants: "update wp_usermeta set meta_value = 'Jane' where user_id = 5 and meta_key = 'nickname';\n",
+		},
+		{
+			name:  "constraints unknown",
+			query: "UPDATE `wp_usermeta` SET `meta_value`='Jane' WHERE `umeta_id`=12;\n",
+			wants: "update wp_usermeta set meta_value = 'Reece' where umeta_id = 12;\n",
+		},
+		{
+			name:  "other table",
+			query: "UPDATE `wp_posts` SET `post_title`='Hello' WHERE `ID`=1;\n",
+			wants: "update wp_posts set post_title = 'Hello' where ID = 1;\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			faker.Seed(432)
+
+			result := processString(t, config, test.query)
+
+			if result != test.wants {
+				t.Error("\nExpected:\n", test.wants, "\nActual:\n", result)
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"github.com/xwb1989/sqlparser"
+)
+
+// Null policies decide what happens to a NULL found in a configured field.
+const (
+	// nullPolicyKeep leaves NULL values as they are. This is the default.
+	nullPolicyKeep = "keep"
+	// nullPolicyReplace replaces NULL values like any other value.
+	nullPolicyReplace = "replace"
+	// nullPolicyEmpty replaces NULL values with an empty string.
+	nullPolicyEmpty = "empty"
+)
+
+func validateNullPolicy(nullPolicy string) error {
+	switch nullPolicy {
+	case "", nullPolicyKeep, nullPolicyReplace, nullPolicyEmpty:
+		return nil
+	}
+	return fmt.Errorf("unknown null policy %q", nullPolicy)
+}
+
+// valueToTransform returns the literal value of a configured field that should
+// be handed to the transformation function. If the value should be left as it
+// is, nil is returned instead, and if it should be swapped for something other
+// than the transformation's result, that's returned as the replacement.
+func valueToTransform(expr sqlparser.Expr, fieldPattern PatternField) (value *sqlparser.SQLVal, replacement sqlparser.Expr) {
+	switch expr := expr.(type) {
+	case *sqlparser.SQLVal:
+		// Skipping applying a transformation because field is empty
+		if len(expr.Val) == 0 {
+			return nil, nil
+		}
+		return expr, nil
+
+	case *sqlparser.NullVal:
+		switch fieldPattern.NullPolicy {
+		case nullPolicyReplace:
+			return sqlparser.NewStrVal([]byte{}), nil
+		case nullPolicyEmpty:
+			return nil, sqlparser.NewStrVal([]byte{})
+		}
+		return nil, nil
+
+	case *sqlparser.UnaryExpr:
+		// Negative numbers are parsed as a minus sign in front of the number, but
+		// they're as much data as any other number
+		number, ok := expr.Expr.(*sqlparser.SQLVal)
+		if ok && expr.Operator == sqlparser.UMinusStr && (number.Type == sqlparser.IntVal || number.Type == sqlparser.FloatVal) {
+			return &sqlparser.SQLVal{Type: number.Type, Val: append([]byte("-"), number.Val...)}, nil
+		}
+	}
+
+	// Booleans, function calls such as NOW() and the like aren't data we can
+	// generate a meaningful replacement for, so leave them be. The value isn't
+	// logged, as it could be exactly what we're meant to be keeping out of sight.
+	logrus.WithFields(logrus.Fields{
+		"field": fieldPattern.Field,
+		"type":  fmt.Sprintf("%T", expr),
+	}).Warn("Skipping transformation of value that isn't a literal")
+	return nil, nil
+}
+
+// transformExpr returns what a value of a configured field is replaced with,
+// which is the value itself when it's left as it is.
+func transformExpr(expr sqlparser.Expr, fieldPattern PatternField, pseudonymizer *Pseudonymizer) sqlparser.Expr {
+	value, replacement := valueToTransform(expr, fieldPattern)
+	if value != nil {
+		return pseudonymizer.transform(fieldPattern, value)
+	}
+	if replacement != nil {
+		return replacement
+	}
+	return expr
+}
+
+// exprToString returns the value of an expression as it would compare in
+// MySQL, along with whether it's NULL.
+func exprToString(expr sqlparser.Expr) (string, bool) {
+	switch expr := expr.(type) {
+	case *sqlparser.SQLVal:
+		return convertSQLValToString(expr), false
+	case *sqlparser.NullVal:
+		return "", true
+	case sqlparser.BoolVal:
+		if expr {
+			return "1", false
+		}
+		return "0", false
+	}
+	return sqlparser.String(expr), false
+}